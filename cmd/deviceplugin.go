@@ -1,6 +1,9 @@
 package cmd
 
 import (
+	"fmt"
+	"time"
+
 	dp "github.com/everpeace/k8s-hostpath-device-plugin/pkg/deviceplugin"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
@@ -8,20 +11,34 @@ import (
 
 var (
 	configFilePath string
+	runnerCfg      = dp.RunnerConfig{
+		HealthListen:              ":8080",
+		RegistrationCheckInterval: 30 * time.Second,
+	}
 )
 
 // devicepluginCmd represents the deviceplugin command
 var devicepluginCmd = &cobra.Command{
 	Use:   "deviceplugin",
 	Short: "start device plugin",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if runnerCfg.RegistrationCheckInterval < 0 {
+			return fmt.Errorf("--registration-check-interval must not be negative, but got %s", runnerCfg.RegistrationCheckInterval)
+		}
+		return nil
+	},
 	Run: func(cmd *cobra.Command, args []string) {
 		mustLoadConfig()
+		runnerCfg.ConfigFile = configFilePath
+		runnerCfg.StrictConfig = strictConfig
 		log.Info().Msg("Starging K8s HostPath Device Plugin")
-		dp.MustNewRunner(cfg).Run()
+		dp.MustNewRunner(cfg, runnerCfg).Run()
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(devicepluginCmd)
 	devicepluginCmd.PersistentFlags().StringVar(&configFilePath, "config", "/k8s-hostpath-device-plugin/config.yaml", "config file path")
+	devicepluginCmd.Flags().StringVar(&runnerCfg.HealthListen, "health-listen", runnerCfg.HealthListen, "listen address of health endpoints(/healthz and /readyz). disabled if empty")
+	devicepluginCmd.Flags().DurationVar(&runnerCfg.RegistrationCheckInterval, "registration-check-interval", runnerCfg.RegistrationCheckInterval, "interval of verifying device plugins are still served and registered to kubelet. disabled if 0")
 }
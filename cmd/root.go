@@ -10,12 +10,13 @@ import (
 )
 
 var (
-	debug     bool
-	logPretty bool
+	debug        bool
+	logPretty    bool
+	strictConfig bool
 )
 
 var (
-	cfg config.HostPathDevicePluginConfig
+	cfg config.Config
 )
 
 var rootCmd = &cobra.Command{
@@ -35,7 +36,7 @@ var rootCmd = &cobra.Command{
 }
 
 func mustLoadConfig() {
-	cfg = config.MustLoadConfig(configFilePath)
+	cfg = config.MustLoadConfig(configFilePath, strictConfig)
 }
 
 func Execute() {
@@ -45,5 +46,6 @@ func Execute() {
 func init() {
 	rootCmd.CompletionOptions.DisableDefaultCmd = true
 	rootCmd.PersistentFlags().BoolVar(&debug, "debug", false, "sets log level to debug")
+	rootCmd.PersistentFlags().BoolVar(&strictConfig, "strict-config", true, "reject unknown fields and multiple documents in config file. set false to ignore them")
 	rootCmd.PersistentFlags().BoolVar(&logPretty, "log-pretty", true, "set pretty logging(human-friendly & colorized output), json logging if false")
 }
@@ -0,0 +1,26 @@
+package cmd
+
+import (
+	"encoding/json"
+
+	"github.com/everpeace/k8s-hostpath-device-plugin/pkg/config"
+	"github.com/spf13/cobra"
+)
+
+// schemaCmd represents the schema command
+var schemaCmd = &cobra.Command{
+	Use:   "schema",
+	Short: "print JSON Schema of config file",
+	Long: `Print JSON Schema of config file.  Editors and policy tools can validate config files with it before they ` +
+		`reach the cluster.  Some constraints(e.g. glob patterns and Go templates) are only checked by validate command.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		enc := json.NewEncoder(cmd.OutOrStdout())
+		enc.SetIndent("", "  ")
+		return enc.Encode(config.JSONSchema())
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(schemaCmd)
+}
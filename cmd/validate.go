@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/everpeace/k8s-hostpath-device-plugin/pkg/config"
+	"github.com/spf13/cobra"
+)
+
+var (
+	validateOutput string
+)
+
+// validationResult is the result of validate command in JSON output
+type validationResult struct {
+	ConfigFile string              `json:"configFile"`
+	Valid      bool                `json:"valid"`
+	Errors     []config.FieldError `json:"errors"`
+}
+
+// validateCmd represents the validate command
+var validateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "validate config file",
+	Long:  `Validate config file and report all the errors.  It exits with non-zero status if the config file is invalid.`,
+	Args:  cobra.NoArgs,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if validateOutput != "text" && validateOutput != "json" {
+			return fmt.Errorf("--output must be text or json, but got %s", validateOutput)
+		}
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		_, err := config.LoadConfig(configFilePath, strictConfig)
+		result := validationResult{
+			ConfigFile: configFilePath,
+			Valid:      err == nil,
+			Errors:     config.ToFieldErrors(err),
+		}
+		if result.Errors == nil {
+			result.Errors = []config.FieldError{}
+		}
+		printValidationResult(cmd.OutOrStdout(), result)
+		if !result.Valid {
+			os.Exit(1)
+		}
+	},
+}
+
+func printValidationResult(w io.Writer, result validationResult) {
+	if validateOutput == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(result)
+		return
+	}
+	if result.Valid {
+		fmt.Fprintf(w, "%s: valid\n", result.ConfigFile)
+		return
+	}
+	for _, e := range result.Errors {
+		fmt.Fprintf(w, "%s: %s\n", result.ConfigFile, e.Error())
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(validateCmd)
+	validateCmd.Flags().StringVar(&configFilePath, "config", "/k8s-hostpath-device-plugin/config.yaml", "config file path")
+	validateCmd.Flags().StringVarP(&validateOutput, "output", "o", "text", "output format. text or json")
+}
@@ -26,6 +26,8 @@ var webhookCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		ctx := ctrl.SetupSignalHandler()
 		mustLoadConfig()
+		whCfg.ConfigFile = configFilePath
+		whCfg.StrictConfig = strictConfig
 		log.Info().Interface("Config", whCfg).Msg("Loaded webhook server config")
 		server := webhook.NewServer(cfg, whCfg)
 		if err := server.Start(ctx); err != nil {
@@ -37,6 +39,7 @@ var webhookCmd = &cobra.Command{
 
 func init() {
 	rootCmd.AddCommand(webhookCmd)
+	webhookCmd.PersistentFlags().StringVar(&configFilePath, "config", "/k8s-hostpath-device-plugin/config.yaml", "config file path")
 	webhookCmd.PersistentFlags().StringVar(&whCfg.CertFile, "tls-cert-file", whCfg.CertFile, ""+
 		"File containing the default x509 Certificate for HTTPS. (CA cert, if any, concatenated "+
 		"after server cert).")
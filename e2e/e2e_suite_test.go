@@ -42,7 +42,7 @@ var _ = BeforeSuite(func() {
 	k8sClient, err = kubernetes.NewForConfig(clientConfig)
 	Expect(err).ShouldNot(HaveOccurred())
 
-	dpCfg = dpconfig.MustLoadConfig(pluginconfigPath)
+	dpCfg = dpconfig.MustLoadConfig(pluginconfigPath, true).Resources[0]
 })
 
 func init() {
@@ -0,0 +1,93 @@
+package cdi
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// Version is the CDI spec version which this package generates
+	Version = "0.5.0"
+	// DefaultSpecDir is the directory which container runtimes load CDI specs from by default
+	DefaultSpecDir = "/var/run/cdi"
+)
+
+// Spec is a subset of CDI spec (https://github.com/cncf-tags/container-device-interface/blob/main/SPEC.md)
+type Spec struct {
+	Version string   `json:"cdiVersion"`
+	Kind    string   `json:"kind"`
+	Devices []Device `json:"devices"`
+}
+
+// Device is a CDI device
+type Device struct {
+	Name           string         `json:"name"`
+	ContainerEdits ContainerEdits `json:"containerEdits"`
+}
+
+// ContainerEdits describes edits applied to containers requesting the device
+type ContainerEdits struct {
+	Env         []string      `json:"env,omitempty"`
+	DeviceNodes []*DeviceNode `json:"deviceNodes,omitempty"`
+	Mounts      []*Mount      `json:"mounts,omitempty"`
+}
+
+// DeviceNode is a device node injected to containers
+type DeviceNode struct {
+	Path        string `json:"path"`
+	HostPath    string `json:"hostPath,omitempty"`
+	Permissions string `json:"permissions,omitempty"`
+}
+
+// Mount is a mount injected to containers
+type Mount struct {
+	HostPath      string   `json:"hostPath"`
+	ContainerPath string   `json:"containerPath"`
+	Type          string   `json:"type,omitempty"`
+	Options       []string `json:"options,omitempty"`
+}
+
+// QualifiedName returns the fully qualified name of the device(e.g. vendor.com/class=name)
+func QualifiedName(kind, name string) string {
+	return kind + "=" + name
+}
+
+// WriteSpec writes spec to dir/name atomically
+func WriteSpec(dir, name string, spec *Spec) error {
+	b, err := json.MarshalIndent(spec, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, "."+name+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(dir, name))
+}
+
+// RemoveSpec removes the spec file dir/name if exists
+func RemoveSpec(dir, name string) error {
+	if err := os.Remove(filepath.Join(dir, name)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
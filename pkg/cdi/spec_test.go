@@ -0,0 +1,85 @@
+package cdi
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Spec", func() {
+	var dir string
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "cdi")
+		Expect(err).ShouldNot(HaveOccurred())
+	})
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).Should(Succeed())
+	})
+
+	spec := &Spec{
+		Version: Version,
+		Kind:    "test.org/test-resource",
+		Devices: []Device{{
+			Name: "hostpath",
+			ContainerEdits: ContainerEdits{
+				Env:         []string{"A=a"},
+				DeviceNodes: []*DeviceNode{{Path: "/dev/fuse", HostPath: "/dev/fuse", Permissions: "rw"}},
+				Mounts:      []*Mount{{HostPath: "/mnt/a", ContainerPath: "/data", Type: "bind", Options: []string{"rbind", "ro"}}},
+			},
+		}},
+	}
+
+	It("should return the fully qualified device name", func() {
+		Expect(QualifiedName("test.org/test-resource", "hostpath")).Should(Equal("test.org/test-resource=hostpath"))
+	})
+
+	It("should write the spec atomically and remove it", func() {
+		specDir := filepath.Join(dir, "cdi")
+		Expect(WriteSpec(specDir, "test.json", spec)).Should(Succeed())
+
+		// no temporary files are left
+		entries, err := os.ReadDir(specDir)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(entries).Should(HaveLen(1))
+		Expect(entries[0].Name()).Should(Equal("test.json"))
+		fi, err := os.Stat(filepath.Join(specDir, "test.json"))
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(fi.Mode().Perm()).Should(Equal(os.FileMode(0644)))
+
+		b, err := os.ReadFile(filepath.Join(specDir, "test.json"))
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(b).Should(MatchJSON(`{
+  "cdiVersion": "0.5.0",
+  "kind": "test.org/test-resource",
+  "devices": [{
+    "name": "hostpath",
+    "containerEdits": {
+      "env": ["A=a"],
+      "deviceNodes": [{"path": "/dev/fuse", "hostPath": "/dev/fuse", "permissions": "rw"}],
+      "mounts": [{"hostPath": "/mnt/a", "containerPath": "/data", "type": "bind", "options": ["rbind", "ro"]}]
+    }
+  }]
+}`))
+		var read Spec
+		Expect(json.Unmarshal(b, &read)).Should(Succeed())
+		Expect(&read).Should(Equal(spec))
+
+		// overwritten
+		updated := *spec
+		updated.Kind = "test.org/updated"
+		Expect(WriteSpec(specDir, "test.json", &updated)).Should(Succeed())
+		b, err = os.ReadFile(filepath.Join(specDir, "test.json"))
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(json.Unmarshal(b, &read)).Should(Succeed())
+		Expect(read.Kind).Should(Equal("test.org/updated"))
+
+		Expect(RemoveSpec(specDir, "test.json")).Should(Succeed())
+		Expect(filepath.Join(specDir, "test.json")).ShouldNot(BeAnExistingFile())
+		// removing a missing spec succeeds
+		Expect(RemoveSpec(specDir, "test.json")).Should(Succeed())
+	})
+})
@@ -1,13 +1,26 @@
 package config
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"maps"
 	"os"
+	"path/filepath"
+	"reflect"
 	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+	"text/template/parse"
 	"time"
 
 	"k8s.io/apimachinery/pkg/util/yaml"
 
+	"github.com/everpeace/k8s-hostpath-device-plugin/pkg/cdi"
 	"github.com/go-playground/validator/v10"
+	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
 	corev1 "k8s.io/api/core/v1"
 	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
@@ -15,10 +28,56 @@ import (
 
 const (
 	defaultHealthCheckInterval = time.Duration(10) * time.Second
+	defaultDevicePermissions   = "rwm"
+	defaultProbeTimeout        = time.Duration(5) * time.Second
+	defaultGCInterval          = time.Duration(1) * time.Minute
+	defaultGCGracePeriod       = time.Duration(5) * time.Minute
+	defaultPodResourcesSocket  = "/var/lib/kubelet/pod-resources/kubelet.sock"
 )
 
 var (
 	validate *validator.Validate
+	// fileModePattern is the format of file modes in octal like "0750".  It is shared by
+	// FileModeValidation and the JSON Schema.
+	fileModePattern = regexp.MustCompile(`^0?[0-7]{1,4}$`)
+)
+
+// InjectionMode specifies how the host path is injected to containers
+type InjectionMode string
+
+const (
+	// InjectionWebhook lets the webhook declare hostPath volume and volumeMounts to the Pods
+	InjectionWebhook InjectionMode = "webhook"
+	// InjectionAllocate lets the device plugin return mounts in Allocate response
+	InjectionAllocate InjectionMode = "allocate"
+	// InjectionBoth does both InjectionWebhook and InjectionAllocate except that Allocate doesn't return mounts,
+	// which would duplicate the webhook's ones at the same path.  Allocate returns envs and annotations.
+	InjectionBoth InjectionMode = "both"
+	// InjectionCDI lets the device plugin write a CDI spec and return CDI devices in Allocate response
+	InjectionCDI InjectionMode = "cdi"
+)
+
+// Config holds configs of all the hostpath resources served by a single process.  This is the config file
+// in hostpath-device.k8s.io/v1alpha1.  Unversioned config files are converted by ConvertLegacyConfig.
+type Config struct {
+	// APIVersion is the version of the config file
+	APIVersion string `yaml:"apiVersion" validate:"required,oneof=hostpath-device.k8s.io/v1alpha1"`
+	// Kind is the kind of the config file
+	Kind string `yaml:"kind" validate:"required,oneof=HostPathDevicePluginConfig"`
+	// Resources defines hostpath resources which the device plugin serves
+	Resources []HostPathDevicePluginConfig `yaml:"resources" validate:"required,min=1,dive"`
+}
+
+// AllocationPolicy specifies which devices the device plugin prefers kubelet to allocate
+type AllocationPolicy string
+
+const (
+	// AllocationPolicyPack prefers devices backed by the same path to pack allocations onto fewer disks
+	AllocationPolicyPack AllocationPolicy = "pack"
+	// AllocationPolicySpread prefers devices backed by distinct paths to spread allocations across disks
+	AllocationPolicySpread AllocationPolicy = "spread"
+	// AllocationPolicyLRU prefers least recently allocated devices
+	AllocationPolicyLRU AllocationPolicy = "lru"
 )
 
 // HostPathDevicePluginConfig holds a config for HostPathDevicePlugin
@@ -31,10 +90,149 @@ type HostPathDevicePluginConfig struct {
 	HostPath corev1.HostPathVolumeSource `yaml:"hostPath"`
 	// VolumeMount specifies how the extended resource mounts the HostPath to containers.  Name field will be ignored.
 	VolumeMount corev1.VolumeMount `yaml:"volumeMount"`
-	// NumDevices specifies how many extended resource the device plugin serves
+	// NumDevices specifies how many extended resource the device plugin serves.  When DevicePaths is specified,
+	// this is the number of devices per path.
 	NumDevices int `yaml:"numDevices" validate:"min=1"`
-	// HealthCheckInterval specifies the healthcheck interval of the Spec.HostPath
-	HealthCheckInterval time.Duration `yaml:"healthCheckInterval"`
+	// DevicePaths specifies a glob pattern(e.g. /mnt/disk*) of host paths backing devices.  Each matched path
+	// backs NumDevices devices whose health is checked independently.  Defaults to HostPath.Path.
+	DevicePaths string `yaml:"devicePaths" validate:"omitempty,glob"`
+	// HealthCheckInterval specifies the interval of periodic healthcheck of the Spec.HostPath.  Health is also
+	// checked on filesystem events of the HostPath and its parent directories.  So, this is a fallback resync.
+	HealthCheckInterval time.Duration `yaml:"healthCheckInterval" validate:"min=0"`
+	// Injection specifies how the HostPath is injected to containers.  Defaults to "webhook".
+	// The webhook still validates Pods not to declare the HostPath directly in "allocate" mode.
+	Injection InjectionMode `yaml:"injection" validate:"omitempty,oneof=webhook allocate both cdi"`
+	// CDISpecDir specifies the directory which the CDI spec is written to in "cdi" injection mode.  Defaults to "/var/run/cdi".
+	CDISpecDir string `yaml:"cdiSpecDir"`
+	// DeviceNodes specifies device nodes on the host which are exposed to containers with cgroup device permissions
+	DeviceNodes []DeviceNode `yaml:"deviceNodes" validate:"dive"`
+	// Envs specifies environment variables set to containers.  Values are Go templates rendered with TemplateData.
+	Envs map[string]string `yaml:"envs" validate:"dive,template"`
+	// Annotations specifies annotations set to containers.  Values are Go templates rendered with TemplateData.
+	Annotations map[string]string `yaml:"annotations" validate:"dive,template"`
+	// Probes specifies health probes of the HostPath in addition to its existence
+	Probes []Probe `yaml:"probes" validate:"dive"`
+	// Exclusive makes each device backed by its own directory so that containers never share the same
+	// directory.  Each device is backed by the path matched by DevicePaths when NumDevices is 1, otherwise
+	// by the subdirectory named by its device ID.  Requires "allocate" injection mode.
+	Exclusive bool `yaml:"exclusive"`
+	// AllocationPolicy specifies the policy of preferred allocation, one of "pack", "spread" and "lru".
+	// When empty, the device plugin doesn't advertise preferred allocation and kubelet picks devices.
+	AllocationPolicy AllocationPolicy `yaml:"allocationPolicy" validate:"omitempty,oneof=pack spread lru"`
+	// NUMANodes specifies NUMA nodes which devices are attached to.  These are reported to kubelet as topology
+	// hints for Topology Manager.  When DetectNUMANode is true, this is the fallback for undetectable paths.
+	NUMANodes []int64 `yaml:"numaNodes" validate:"dive,min=0"`
+	// DetectNUMANode detects the NUMA node of each path backing devices from sysfs when the path is on a block device
+	DetectNUMANode bool `yaml:"detectNUMANode"`
+	// PreStart enables PreStartContainer which prepares directories mounted to containers before they start
+	PreStart *PreStart `yaml:"preStart"`
+	// GarbageCollection enables garbage collection of directories backing devices no longer allocated.  Requires Exclusive.
+	GarbageCollection *GarbageCollection `yaml:"garbageCollection"`
+}
+
+// GarbageCollection specifies garbage collection of subdirectories backing devices in Exclusive mode.  Allocated
+// devices are looked up by kubelet PodResources API.
+type GarbageCollection struct {
+	// Interval specifies the interval of garbage collection.  Defaults to 1m.
+	Interval time.Duration `yaml:"interval" validate:"min=0"`
+	// GracePeriod specifies how long directories are kept after devices are deallocated.  Defaults to 5m.
+	GracePeriod time.Duration `yaml:"gracePeriod" validate:"min=0"`
+	// DryRun only logs directories to be removed
+	DryRun bool `yaml:"dryRun"`
+	// PodResourcesSocket specifies the unix socket of kubelet PodResources API.
+	// Defaults to "/var/lib/kubelet/pod-resources/kubelet.sock".
+	PodResourcesSocket string `yaml:"podResourcesSocket"`
+}
+
+// PreStart specifies how directories are prepared before containers start.  Directories are those backing
+// the allocated devices in Exclusive mode, otherwise the HostPath.  They are created when they don't exist.
+type PreStart struct {
+	// UID specifies the owner user ID of the directories.  The owner is kept when unset.  Requires Exclusive.
+	UID *int64 `yaml:"uid" validate:"omitempty,min=0"`
+	// GID specifies the owner group ID of the directories.  The group is kept when unset.  Requires Exclusive.
+	GID *int64 `yaml:"gid" validate:"omitempty,min=0"`
+	// Mode specifies permission bits of the directories in octal(e.g. "0750").  Permissions are kept when unset.
+	// Requires Exclusive.
+	Mode string `yaml:"mode" validate:"omitempty,filemode"`
+	// Wipe removes all the contents of the directories every time containers start.  Requires Exclusive.
+	Wipe bool `yaml:"wipe"`
+}
+
+// FileMode returns Mode as os.FileMode.  Unix setuid, setgid and sticky bits(04000, 02000 and 01000)
+// are mapped to os.ModeSetuid, os.ModeSetgid and os.ModeSticky because os.FileMode has its own flag bits.
+func (p PreStart) FileMode() os.FileMode {
+	mode, _ := strconv.ParseUint(p.Mode, 8, 32)
+	fileMode := os.FileMode(mode) & os.ModePerm
+	if mode&04000 != 0 {
+		fileMode |= os.ModeSetuid
+	}
+	if mode&02000 != 0 {
+		fileMode |= os.ModeSetgid
+	}
+	if mode&01000 != 0 {
+		fileMode |= os.ModeSticky
+	}
+	return fileMode
+}
+
+// ProbeType is a type of health probe
+type ProbeType string
+
+const (
+	// ProbeMountPoint checks the HostPath is a mount point
+	ProbeMountPoint ProbeType = "mountPoint"
+	// ProbeFSType checks the filesystem type of the HostPath is one of FSTypes
+	ProbeFSType ProbeType = "fsType"
+	// ProbeWritable checks a file can be created and removed in the HostPath
+	ProbeWritable ProbeType = "writable"
+	// ProbeFreeSpace checks the filesystem of the HostPath has at least MinFreeBytes and MinFreeInodes
+	ProbeFreeSpace ProbeType = "freeSpace"
+	// ProbeFileExists checks File exists in the HostPath
+	ProbeFileExists ProbeType = "fileExists"
+	// ProbeExec checks Command exits with 0 within Timeout
+	ProbeExec ProbeType = "exec"
+)
+
+// Probe specifies a health probe of the HostPath
+type Probe struct {
+	// Type is the type of the probe
+	Type ProbeType `yaml:"type" validate:"required,oneof=mountPoint fsType writable freeSpace fileExists exec"`
+	// Optional makes failures of the probe only logged.  Otherwise, devices become Unhealthy when the probe fails.
+	Optional bool `yaml:"optional"`
+	// FSTypes specifies expected filesystem types(e.g. ext4, xfs, nfs4) for "fsType" probe
+	FSTypes []string `yaml:"fsTypes" validate:"required_if=Type fsType"`
+	// MinFreeBytes specifies minimum available bytes for "freeSpace" probe
+	MinFreeBytes uint64 `yaml:"minFreeBytes"`
+	// MinFreeInodes specifies minimum free inodes for "freeSpace" probe
+	MinFreeInodes uint64 `yaml:"minFreeInodes"`
+	// File specifies a file path relative to the HostPath for "fileExists" probe
+	File string `yaml:"file" validate:"required_if=Type fileExists"`
+	// Command specifies a command for "exec" probe.  HOST_PATH environment variable is set to the HostPath.
+	Command []string `yaml:"command" validate:"required_if=Type exec"`
+	// Timeout specifies timeout of "exec" probe.  Defaults to 5s.
+	Timeout time.Duration `yaml:"timeout" validate:"min=0"`
+}
+
+// TemplateData is the data which Envs and Annotations are rendered with
+type TemplateData struct {
+	// ResourceName is the extended resource name
+	ResourceName string
+	// HostPath is the path of HostPath
+	HostPath string
+	// MountPath is the path of VolumeMount
+	MountPath string
+	// DeviceIDs is the allocated device IDs.  This is empty when rendered by the webhook.
+	DeviceIDs []string
+}
+
+// DeviceNode specifies a device node(e.g. /dev/fuse) on the host
+type DeviceNode struct {
+	// HostPath is the path of the device node on the host
+	HostPath string `yaml:"hostPath" validate:"required"`
+	// ContainerPath is the path of the device node in containers.  Defaults to HostPath.
+	ContainerPath string `yaml:"containerPath"`
+	// Permissions is cgroup permissions of the device node, combination of "r", "w" and "m".  Defaults to "rwm".
+	Permissions string `yaml:"permissions" validate:"omitempty,devicepermissions"`
 }
 
 func (c HostPathDevicePluginConfig) Socket() string {
@@ -45,31 +243,302 @@ func (c HostPathDevicePluginConfig) HostPathVolumeName() string {
 	return "hostpath-device-volume-" + regexp.MustCompile(`[./]`).ReplaceAllString(c.ResourceName, "-")
 }
 
-func MustLoadConfig(configPath string) HostPathDevicePluginConfig {
+// InjectsByWebhook returns true when the webhook should inject the HostPath to containers
+func (c HostPathDevicePluginConfig) InjectsByWebhook() bool {
+	return c.Injection == "" || c.Injection == InjectionWebhook || c.Injection == InjectionBoth
+}
+
+// InjectsByAllocate returns true when Allocate should return envs and annotations, and mounts of the HostPath
+// unless the webhook also injects it
+func (c HostPathDevicePluginConfig) InjectsByAllocate() bool {
+	return c.Injection == InjectionAllocate || c.Injection == InjectionBoth
+}
+
+// InjectsByCDI returns true when Allocate should return CDI devices
+func (c HostPathDevicePluginConfig) InjectsByCDI() bool {
+	return c.Injection == InjectionCDI
+}
+
+// CDIKind returns the CDI kind(vendor/class) of the resource
+func (c HostPathDevicePluginConfig) CDIKind() string {
+	vendor, class, _ := strings.Cut(c.ResourceName, "/")
+	return vendor + "/" + regexp.MustCompile(`[^a-zA-Z0-9_-]`).ReplaceAllString(class, "-")
+}
+
+// CDISpecName returns the filename of the CDI spec of the resource
+func (c HostPathDevicePluginConfig) CDISpecName() string {
+	return regexp.MustCompile(`[./]`).ReplaceAllString(c.ResourceName, "-") + ".json"
+}
+
+// RenderEnvs renders Envs for the allocated deviceIDs.  deviceIDs is nil before allocation(i.e. in the
+// webhook and the CDI spec).  Then, Envs referring to .DeviceIDs are skipped.
+func (c HostPathDevicePluginConfig) RenderEnvs(deviceIDs []string) (map[string]string, error) {
+	return c.render(c.Envs, deviceIDs)
+}
+
+// RenderAnnotations renders Annotations for the allocated deviceIDs.  deviceIDs is nil before allocation.
+// Then, Annotations referring to .DeviceIDs are skipped.
+func (c HostPathDevicePluginConfig) RenderAnnotations(deviceIDs []string) (map[string]string, error) {
+	return c.render(c.Annotations, deviceIDs)
+}
+
+func (c HostPathDevicePluginConfig) render(templates map[string]string, deviceIDs []string) (map[string]string, error) {
+	if len(templates) == 0 {
+		return nil, nil
+	}
+	data := TemplateData{
+		ResourceName: c.ResourceName,
+		HostPath:     c.HostPath.Path,
+		MountPath:    c.VolumeMount.MountPath,
+		DeviceIDs:    deviceIDs,
+	}
+	rendered := make(map[string]string, len(templates))
+	for k, v := range templates {
+		tmpl, err := newTemplate(k).Parse(v)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse template of %s", k)
+		}
+		// they are rendered in Allocate
+		if deviceIDs == nil && refersDeviceIDs(tmpl.Tree.Root) {
+			continue
+		}
+		var b strings.Builder
+		if err := tmpl.Execute(&b, data); err != nil {
+			return nil, errors.Wrapf(err, "failed to render template of %s", k)
+		}
+		rendered[k] = b.String()
+	}
+	return rendered, nil
+}
+
+// refersDeviceIDs returns true when node refers to .DeviceIDs, which is known only in Allocate
+func refersDeviceIDs(node parse.Node) bool {
+	hasDeviceIDs := func(ident []string) bool {
+		return slices.Contains(ident, "DeviceIDs")
+	}
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return false
+		}
+		for _, child := range n.Nodes {
+			if refersDeviceIDs(child) {
+				return true
+			}
+		}
+	case *parse.ActionNode:
+		return refersDeviceIDs(n.Pipe)
+	case *parse.TemplateNode:
+		return n.Pipe != nil && refersDeviceIDs(n.Pipe)
+	case *parse.IfNode:
+		return refersDeviceIDs(&n.BranchNode)
+	case *parse.RangeNode:
+		return refersDeviceIDs(&n.BranchNode)
+	case *parse.WithNode:
+		return refersDeviceIDs(&n.BranchNode)
+	case *parse.BranchNode:
+		return refersDeviceIDs(n.Pipe) || refersDeviceIDs(n.List) || refersDeviceIDs(n.ElseList)
+	case *parse.PipeNode:
+		if n == nil {
+			return false
+		}
+		for _, cmd := range n.Cmds {
+			if refersDeviceIDs(cmd) {
+				return true
+			}
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			if refersDeviceIDs(arg) {
+				return true
+			}
+		}
+	case *parse.FieldNode:
+		return hasDeviceIDs(n.Ident)
+	case *parse.VariableNode:
+		return hasDeviceIDs(n.Ident)
+	case *parse.ChainNode:
+		return hasDeviceIDs(n.Field) || refersDeviceIDs(n.Node)
+	}
+	return false
+}
+
+func newTemplate(name string) *template.Template {
+	return template.New(name).Option("missingkey=error").Funcs(template.FuncMap{
+		"join": strings.Join,
+	})
+}
+
+// MustLoadConfig loads a config file by LoadConfig.  It exits when the config file is invalid.
+func MustLoadConfig(configPath string, strict bool) Config {
 	logger := log.With().Str("ConfigFile", configPath).Logger()
 
-	f, err := os.Open(configPath)
+	config, err := LoadConfig(configPath, strict)
 	if err != nil {
-		logger.Fatal().Err(err).Msg("Failed to open config file")
+		logger.Fatal().Err(err).Msg("Failed to load config")
 	}
 
-	var config HostPathDevicePluginConfig
-	decoder := yaml.NewYAMLOrJSONDecoder(f, 256)
-	if err := decoder.Decode(&config); err != nil {
-		logger.Fatal().Err(err).Msg("Failed to parse config file")
+	logger.Info().Interface("Config", config).Msg("Config loaded")
+	return config
+}
+
+// LoadConfig loads a config file in hostpath-device.k8s.io/v1alpha1 or the deprecated unversioned format
+// with a single resource, which is converted with a warning.  It returns an error when the file can't be
+// parsed, or Errors holding all the validation errors.  When strict is true, unknown fields are reported as
+// errors with their lines, and the file must have a single document.  Otherwise, unknown fields are ignored
+// and documents other than the first one are not read.
+func LoadConfig(configPath string, strict bool) (Config, error) {
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return Config{}, errors.Wrap(err, "failed to open config file")
+	}
+
+	var raw json.RawMessage
+	decoder := yaml.NewYAMLOrJSONDecoder(bytes.NewReader(data), 256)
+	if err := decoder.Decode(&raw); err != nil {
+		return Config{}, errors.Wrap(err, "failed to parse config file")
+	}
+	if strict {
+		if err := checkSingleDocument(decoder); err != nil {
+			return Config{}, errors.Wrap(err, "failed to parse config file")
+		}
 	}
 
+	config, format, err := decodeConfig(raw)
+	if err != nil {
+		return Config{}, errors.Wrap(err, "failed to parse config file")
+	}
+	if format != formatV1Alpha1 {
+		log.Warn().Str("ConfigFile", configPath).Msgf(
+			"Config file without apiVersion is deprecated. Set apiVersion: %s and kind: %s, and declare resources in resources field",
+			APIVersionV1Alpha1, Kind,
+		)
+	}
+
+	var errs Errors
+	if strict {
+		if errs, err = unknownFields(data, format); err != nil {
+			return Config{}, err
+		}
+	}
 	if err := validate.Struct(&config); err != nil {
-		validationErrors := err.(validator.ValidationErrors)
-		logger.Fatal().Err(validationErrors).Msg("Failed to validate config")
+		errs = append(errs, newErrors(err.(validator.ValidationErrors), format == formatLegacy)...)
+	}
+	if len(errs) > 0 {
+		return Config{}, errs
 	}
 
-	if config.HealthCheckInterval == 0 {
-		config.HealthCheckInterval = defaultHealthCheckInterval
+	SetDefaults(&config)
+	return config, nil
+}
+
+func setDefaults(c *HostPathDevicePluginConfig) {
+	if c.HealthCheckInterval == 0 {
+		c.HealthCheckInterval = defaultHealthCheckInterval
+	}
+	if c.Injection == "" {
+		c.Injection = InjectionWebhook
+	}
+	if c.CDISpecDir == "" {
+		c.CDISpecDir = cdi.DefaultSpecDir
+	}
+	for i := range c.Probes {
+		if c.Probes[i].Timeout == 0 {
+			c.Probes[i].Timeout = defaultProbeTimeout
+		}
 	}
+	if gc := c.GarbageCollection; gc != nil {
+		if gc.Interval == 0 {
+			gc.Interval = defaultGCInterval
+		}
+		if gc.GracePeriod == 0 {
+			gc.GracePeriod = defaultGCGracePeriod
+		}
+		if gc.PodResourcesSocket == "" {
+			gc.PodResourcesSocket = defaultPodResourcesSocket
+		}
+	}
+	for i := range c.DeviceNodes {
+		if c.DeviceNodes[i].ContainerPath == "" {
+			c.DeviceNodes[i].ContainerPath = c.DeviceNodes[i].HostPath
+		}
+		if c.DeviceNodes[i].Permissions == "" {
+			c.DeviceNodes[i].Permissions = defaultDevicePermissions
+		}
+	}
+}
 
-	logger.Info().Interface("Config", config).Msg("Config loaded")
-	return config
+// ConfigValidation validates ResourceName and SocketName are unique across resources.  Errors are
+// reported on duplicated ones so that validation errors of all the resources are reported.
+func ConfigValidation(sl validator.StructLevel) {
+	c := sl.Current().Interface().(Config)
+
+	resourceNames, socketNames := map[string]bool{}, map[string]bool{}
+	for i, r := range c.Resources {
+		if resourceNames[r.ResourceName] {
+			sl.ReportError(r.ResourceName, fmt.Sprintf("resources[%d].resourceName", i), fmt.Sprintf("Resources[%d].ResourceName", i), "unique", "")
+		}
+		if socketNames[r.SocketName] {
+			sl.ReportError(r.SocketName, fmt.Sprintf("resources[%d].socketName", i), fmt.Sprintf("Resources[%d].SocketName", i), "unique", "")
+		}
+		resourceNames[r.ResourceName], socketNames[r.SocketName] = true, true
+	}
+}
+
+// HostPathDevicePluginConfigValidation validates constraints across fields
+func HostPathDevicePluginConfigValidation(sl validator.StructLevel) {
+	c := sl.Current().Interface().(HostPathDevicePluginConfig)
+
+	// the webhook can't know allocated device IDs and would mount the whole HostPath
+	if c.Exclusive && c.Injection != InjectionAllocate {
+		sl.ReportError(c.Exclusive, "exclusive", "Exclusive", "injection", "")
+	}
+	// wiping the HostPath shared by containers would break running containers
+	if c.PreStart != nil && c.PreStart.Wipe && !c.Exclusive {
+		sl.ReportError(c.PreStart.Wipe, "preStart.wipe", "PreStart.Wipe", "exclusive", "")
+	}
+	// changing the owner or permissions of the shared HostPath would break running containers, too
+	if c.PreStart != nil && !c.Exclusive {
+		if c.PreStart.UID != nil {
+			sl.ReportError(*c.PreStart.UID, "preStart.uid", "PreStart.UID", "exclusive", "")
+		}
+		if c.PreStart.GID != nil {
+			sl.ReportError(*c.PreStart.GID, "preStart.gid", "PreStart.GID", "exclusive", "")
+		}
+		if c.PreStart.Mode != "" {
+			sl.ReportError(c.PreStart.Mode, "preStart.mode", "PreStart.Mode", "exclusive", "")
+		}
+	}
+	// directories are per device only in Exclusive mode
+	if c.GarbageCollection != nil && !c.Exclusive {
+		sl.ReportError(c.GarbageCollection, "garbageCollection", "GarbageCollection", "exclusive", "")
+	}
+	// device IDs are known only in Allocate.  Envs are rendered in Allocate only by InjectsByAllocate, and
+	// Annotations also in "cdi" mode
+	injection := string(c.Injection)
+	if injection == "" {
+		injection = string(InjectionWebhook)
+	}
+	if !c.InjectsByAllocate() {
+		reportDeviceIDsTemplates(sl, "envs", "Envs", c.Envs, injection)
+	}
+	if !c.InjectsByAllocate() && !c.InjectsByCDI() {
+		reportDeviceIDsTemplates(sl, "annotations", "Annotations", c.Annotations, injection)
+	}
+}
+
+// reportDeviceIDsTemplates reports templates referring to .DeviceIDs, which can't be rendered in the injection mode
+func reportDeviceIDsTemplates(sl validator.StructLevel, fieldName, structFieldName string, templates map[string]string, injection string) {
+	for _, k := range slices.Sorted(maps.Keys(templates)) {
+		tmpl, err := newTemplate(k).Parse(templates[k])
+		if err != nil {
+			// reported by "template" validation
+			continue
+		}
+		if refersDeviceIDs(tmpl.Tree.Root) {
+			sl.ReportError(templates[k], fieldName+"["+k+"]", structFieldName+"["+k+"]", "deviceids", injection)
+		}
+	}
 }
 
 func HostPathVolumeValidation(sl validator.StructLevel) {
@@ -78,6 +547,17 @@ func HostPathVolumeValidation(sl validator.StructLevel) {
 	if len(hpv.Path) == 0 {
 		sl.ReportError(hpv.Path, "path", "Path", "required", "")
 	}
+
+	if hpv.Type != nil {
+		switch *hpv.Type {
+		case corev1.HostPathUnset,
+			corev1.HostPathDirectoryOrCreate, corev1.HostPathDirectory,
+			corev1.HostPathFileOrCreate, corev1.HostPathFile,
+			corev1.HostPathSocket, corev1.HostPathCharDev, corev1.HostPathBlockDev:
+		default:
+			sl.ReportError(*hpv.Type, "type", "Type", "oneof", "DirectoryOrCreate Directory FileOrCreate File Socket CharDevice BlockDevice")
+		}
+	}
 }
 
 func VolumeMountValidation(sl validator.StructLevel) {
@@ -88,8 +568,62 @@ func VolumeMountValidation(sl validator.StructLevel) {
 	}
 }
 
+// DevicePermissionsValidation validates the field is cgroup device permissions like "rw" or "rwm"
+func DevicePermissionsValidation(fl validator.FieldLevel) bool {
+	permissions := fl.Field().String()
+	if len(permissions) == 0 {
+		return false
+	}
+	for i, p := range permissions {
+		if !strings.ContainsRune(defaultDevicePermissions, p) || strings.ContainsRune(permissions[i+1:], p) {
+			return false
+		}
+	}
+	return true
+}
+
+// FileModeValidation validates the field is permission bits in octal like "0750"
+func FileModeValidation(fl validator.FieldLevel) bool {
+	return fileModePattern.MatchString(fl.Field().String())
+}
+
+// GlobValidation validates the field is a valid glob pattern
+func GlobValidation(fl validator.FieldLevel) bool {
+	_, err := filepath.Match(fl.Field().String(), "")
+	return err == nil
+}
+
+// TemplateValidation validates the field is a valid Go template
+func TemplateValidation(fl validator.FieldLevel) bool {
+	_, err := newTemplate("").Parse(fl.Field().String())
+	return err == nil
+}
+
 func init() {
 	validate = validator.New()
+	// field paths of errors are the same as the config file
+	validate.RegisterTagNameFunc(func(f reflect.StructField) string {
+		for _, key := range []string{"yaml", "json"} {
+			if name, _, _ := strings.Cut(f.Tag.Get(key), ","); name != "" && name != "-" {
+				return name
+			}
+		}
+		return f.Name
+	})
+	validate.RegisterStructValidation(ConfigValidation, Config{})
+	validate.RegisterStructValidation(HostPathDevicePluginConfigValidation, HostPathDevicePluginConfig{})
 	validate.RegisterStructValidation(HostPathVolumeValidation, corev1.HostPathVolumeSource{})
 	validate.RegisterStructValidation(VolumeMountValidation, corev1.VolumeMount{})
+	if err := validate.RegisterValidation("devicepermissions", DevicePermissionsValidation); err != nil {
+		panic(err)
+	}
+	if err := validate.RegisterValidation("template", TemplateValidation); err != nil {
+		panic(err)
+	}
+	if err := validate.RegisterValidation("glob", GlobValidation); err != nil {
+		panic(err)
+	}
+	if err := validate.RegisterValidation("filemode", FileModeValidation); err != nil {
+		panic(err)
+	}
 }
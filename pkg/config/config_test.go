@@ -1,6 +1,10 @@
 package config
 
 import (
+	"os"
+	"path/filepath"
+	"time"
+
 	"github.com/go-playground/validator/v10"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -8,9 +12,29 @@ import (
 	corev1 "k8s.io/api/core/v1"
 )
 
+// validResource returns a valid HostPathDevicePluginConfig named name.  Specs mutate it to make
+// it invalid.
+func validResource(name string) HostPathDevicePluginConfig {
+	return HostPathDevicePluginConfig{
+		ResourceName: "test.org/" + name,
+		SocketName:   name,
+		HostPath: corev1.HostPathVolumeSource{
+			Path: "/mnt/" + name,
+		},
+		VolumeMount: corev1.VolumeMount{
+			MountPath: "/mnt/" + name,
+		},
+		NumDevices: 100,
+	}
+}
+
 var _ = Describe("Validation for HostPathDevicePluginConfig", func() {
 	GetStructNamespace := func(e validator.FieldError) string { return e.StructNamespace() }
 	GetTag := func(e validator.FieldError) string { return e.Tag() }
+	var c HostPathDevicePluginConfig
+	BeforeEach(func() {
+		c = validResource("test-resource")
+	})
 
 	When("valid config", func() {
 		It("should succeed", func() {
@@ -28,6 +52,254 @@ var _ = Describe("Validation for HostPathDevicePluginConfig", func() {
 			})).ShouldNot(HaveOccurred())
 		})
 	})
+	When("injection is invalid", func() {
+		It("should raise validation error", func() {
+			c.Injection = "unknown"
+			Expect(validate.Struct(&c)).To(MatchAllElementsWithIndex(IndexIdentity, Elements{
+				"0": SatisfyAll(
+					WithTransform(GetStructNamespace, Equal("HostPathDevicePluginConfig.Injection")),
+					WithTransform(GetTag, Equal("oneof")),
+				),
+			}))
+		})
+	})
+	When("exclusive is enabled without allocate injection", func() {
+		It("should raise validation error", func() {
+			c.Exclusive = true
+			for _, injection := range []InjectionMode{"", InjectionWebhook, InjectionBoth, InjectionCDI} {
+				c.Injection = injection
+				Expect(validate.Struct(&c)).To(MatchAllElementsWithIndex(IndexIdentity, Elements{
+					"0": SatisfyAll(
+						WithTransform(GetStructNamespace, Equal("HostPathDevicePluginConfig.Exclusive")),
+						WithTransform(GetTag, Equal("injection")),
+					),
+				}))
+			}
+			c.Injection = InjectionAllocate
+			Expect(validate.Struct(&c)).ShouldNot(HaveOccurred())
+		})
+	})
+	When("allocationPolicy is invalid", func() {
+		It("should raise validation error", func() {
+			c.AllocationPolicy = "random"
+			Expect(validate.Struct(&c)).To(MatchAllElementsWithIndex(IndexIdentity, Elements{
+				"0": SatisfyAll(
+					WithTransform(GetStructNamespace, Equal("HostPathDevicePluginConfig.AllocationPolicy")),
+					WithTransform(GetTag, Equal("oneof")),
+				),
+			}))
+		})
+	})
+	When("numaNodes are invalid", func() {
+		It("should raise validation error", func() {
+			c.NUMANodes = []int64{0, -1}
+			Expect(validate.Struct(&c)).To(MatchAllElementsWithIndex(IndexIdentity, Elements{
+				"0": SatisfyAll(
+					WithTransform(GetStructNamespace, Equal("HostPathDevicePluginConfig.NUMANodes[1]")),
+					WithTransform(GetTag, Equal("min")),
+				),
+			}))
+		})
+	})
+	When("preStart is invalid", func() {
+		It("should raise validation error", func() {
+			uid := int64(-1)
+			c.PreStart = &PreStart{
+				UID:  &uid,
+				Mode: "0789",
+				Wipe: true,
+			}
+			Expect(validate.Struct(&c)).To(MatchAllElementsWithIndex(IndexIdentity, Elements{
+				"0": SatisfyAll(
+					WithTransform(GetStructNamespace, Equal("HostPathDevicePluginConfig.PreStart.UID")),
+					WithTransform(GetTag, Equal("min")),
+				),
+				"1": SatisfyAll(
+					WithTransform(GetStructNamespace, Equal("HostPathDevicePluginConfig.PreStart.Mode")),
+					WithTransform(GetTag, Equal("filemode")),
+				),
+				"2": SatisfyAll(
+					WithTransform(GetStructNamespace, Equal("HostPathDevicePluginConfig.PreStart.Wipe")),
+					WithTransform(GetTag, Equal("exclusive")),
+				),
+				"3": SatisfyAll(
+					WithTransform(GetStructNamespace, Equal("HostPathDevicePluginConfig.PreStart.UID")),
+					WithTransform(GetTag, Equal("exclusive")),
+				),
+				"4": SatisfyAll(
+					WithTransform(GetStructNamespace, Equal("HostPathDevicePluginConfig.PreStart.Mode")),
+					WithTransform(GetTag, Equal("exclusive")),
+				),
+			}))
+		})
+	})
+	When("preStart changes the owner or permissions without exclusive", func() {
+		It("should raise validation error", func() {
+			uid, gid := int64(1000), int64(1000)
+			c.PreStart = &PreStart{UID: &uid, GID: &gid, Mode: "0750"}
+			Expect(validate.Struct(&c)).To(MatchAllElementsWithIndex(IndexIdentity, Elements{
+				"0": SatisfyAll(
+					WithTransform(GetStructNamespace, Equal("HostPathDevicePluginConfig.PreStart.UID")),
+					WithTransform(GetTag, Equal("exclusive")),
+				),
+				"1": SatisfyAll(
+					WithTransform(GetStructNamespace, Equal("HostPathDevicePluginConfig.PreStart.GID")),
+					WithTransform(GetTag, Equal("exclusive")),
+				),
+				"2": SatisfyAll(
+					WithTransform(GetStructNamespace, Equal("HostPathDevicePluginConfig.PreStart.Mode")),
+					WithTransform(GetTag, Equal("exclusive")),
+				),
+			}))
+
+			By("only creating the HostPath")
+			c.PreStart = &PreStart{}
+			Expect(validate.Struct(&c)).ShouldNot(HaveOccurred())
+		})
+	})
+	When("garbageCollection is enabled without exclusive", func() {
+		It("should raise validation error", func() {
+			c.GarbageCollection = &GarbageCollection{}
+			Expect(validate.Struct(&c)).To(MatchAllElementsWithIndex(IndexIdentity, Elements{
+				"0": SatisfyAll(
+					WithTransform(GetStructNamespace, Equal("HostPathDevicePluginConfig.GarbageCollection")),
+					WithTransform(GetTag, Equal("exclusive")),
+				),
+			}))
+		})
+	})
+	When("garbageCollection has negative durations", func() {
+		It("should raise validation error", func() {
+			c.Injection = InjectionAllocate
+			c.Exclusive = true
+			c.GarbageCollection = &GarbageCollection{Interval: -1, GracePeriod: -time.Minute}
+			Expect(validate.Struct(&c)).To(MatchAllElementsWithIndex(IndexIdentity, Elements{
+				"0": SatisfyAll(
+					WithTransform(GetStructNamespace, Equal("HostPathDevicePluginConfig.GarbageCollection.Interval")),
+					WithTransform(GetTag, Equal("min")),
+				),
+				"1": SatisfyAll(
+					WithTransform(GetStructNamespace, Equal("HostPathDevicePluginConfig.GarbageCollection.GracePeriod")),
+					WithTransform(GetTag, Equal("min")),
+				),
+			}))
+
+			By("defaulting zero durations")
+			c.GarbageCollection = &GarbageCollection{}
+			Expect(validate.Struct(&c)).ShouldNot(HaveOccurred())
+			setDefaults(&c)
+			Expect(c.GarbageCollection.Interval).Should(BeNumerically(">", 0))
+			Expect(c.GarbageCollection.GracePeriod).Should(BeNumerically(">", 0))
+		})
+	})
+	When("healthCheckInterval and probe timeout are negative", func() {
+		It("should raise validation error", func() {
+			c.HealthCheckInterval = -time.Second
+			c.Probes = []Probe{{Type: ProbeExec, Command: []string{"true"}, Timeout: -1}}
+			Expect(validate.Struct(&c)).To(MatchAllElementsWithIndex(IndexIdentity, Elements{
+				"0": SatisfyAll(
+					WithTransform(GetStructNamespace, Equal("HostPathDevicePluginConfig.HealthCheckInterval")),
+					WithTransform(GetTag, Equal("min")),
+				),
+				"1": SatisfyAll(
+					WithTransform(GetStructNamespace, Equal("HostPathDevicePluginConfig.Probes[0].Timeout")),
+					WithTransform(GetTag, Equal("min")),
+				),
+			}))
+		})
+	})
+	When("deviceNodes are invalid", func() {
+		It("should raise validation error", func() {
+			c.DeviceNodes = []DeviceNode{
+				{HostPath: "/dev/fuse", Permissions: "rw"},
+				{ContainerPath: "/dev/kvm"},
+				{HostPath: "/dev/net/tun", Permissions: "rwx"},
+				{HostPath: "/dev/net/tun", Permissions: "rr"},
+			}
+			Expect(validate.Struct(&c)).To(MatchAllElementsWithIndex(IndexIdentity, Elements{
+				"0": SatisfyAll(
+					WithTransform(GetStructNamespace, Equal("HostPathDevicePluginConfig.DeviceNodes[1].HostPath")),
+					WithTransform(GetTag, Equal("required")),
+				),
+				"1": SatisfyAll(
+					WithTransform(GetStructNamespace, Equal("HostPathDevicePluginConfig.DeviceNodes[2].Permissions")),
+					WithTransform(GetTag, Equal("devicepermissions")),
+				),
+				"2": SatisfyAll(
+					WithTransform(GetStructNamespace, Equal("HostPathDevicePluginConfig.DeviceNodes[3].Permissions")),
+					WithTransform(GetTag, Equal("devicepermissions")),
+				),
+			}))
+		})
+	})
+	When("envs or annotations are invalid templates", func() {
+		It("should raise validation error", func() {
+			c.Envs = map[string]string{"DATA_DIR": "{{ .MountPath"}
+			c.Annotations = map[string]string{"test.org/devices": "{{ unknownFunc .DeviceIDs }}"}
+			Expect(validate.Struct(&c)).To(MatchAllElementsWithIndex(IndexIdentity, Elements{
+				"0": SatisfyAll(
+					WithTransform(GetStructNamespace, Equal("HostPathDevicePluginConfig.Envs[DATA_DIR]")),
+					WithTransform(GetTag, Equal("template")),
+				),
+				"1": SatisfyAll(
+					WithTransform(GetStructNamespace, Equal("HostPathDevicePluginConfig.Annotations[test.org/devices]")),
+					WithTransform(GetTag, Equal("template")),
+				),
+			}))
+		})
+	})
+	When("probes are invalid", func() {
+		It("should raise validation error", func() {
+			c.Probes = []Probe{
+				{Type: ProbeMountPoint},
+				{Type: "unknown"},
+				{Type: ProbeFSType},
+				{Type: ProbeFileExists},
+				{Type: ProbeExec},
+			}
+			Expect(validate.Struct(&c)).To(MatchAllElementsWithIndex(IndexIdentity, Elements{
+				"0": SatisfyAll(
+					WithTransform(GetStructNamespace, Equal("HostPathDevicePluginConfig.Probes[1].Type")),
+					WithTransform(GetTag, Equal("oneof")),
+				),
+				"1": SatisfyAll(
+					WithTransform(GetStructNamespace, Equal("HostPathDevicePluginConfig.Probes[2].FSTypes")),
+					WithTransform(GetTag, Equal("required_if")),
+				),
+				"2": SatisfyAll(
+					WithTransform(GetStructNamespace, Equal("HostPathDevicePluginConfig.Probes[3].File")),
+					WithTransform(GetTag, Equal("required_if")),
+				),
+				"3": SatisfyAll(
+					WithTransform(GetStructNamespace, Equal("HostPathDevicePluginConfig.Probes[4].Command")),
+					WithTransform(GetTag, Equal("required_if")),
+				),
+			}))
+		})
+	})
+	When("devicePaths is invalid glob", func() {
+		It("should raise validation error", func() {
+			c.DevicePaths = "/mnt/disk["
+			Expect(validate.Struct(&c)).To(MatchAllElementsWithIndex(IndexIdentity, Elements{
+				"0": SatisfyAll(
+					WithTransform(GetStructNamespace, Equal("HostPathDevicePluginConfig.DevicePaths")),
+					WithTransform(GetTag, Equal("glob")),
+				),
+			}))
+		})
+	})
+	When("hostPath.type is invalid", func() {
+		It("should raise validation error", func() {
+			invalidType := corev1.HostPathType("Unknown")
+			c.HostPath.Type = &invalidType
+			Expect(validate.Struct(&c)).To(MatchAllElementsWithIndex(IndexIdentity, Elements{
+				"0": SatisfyAll(
+					WithTransform(GetStructNamespace, Equal("HostPathDevicePluginConfig.HostPath.Type")),
+					WithTransform(GetTag, Equal("oneof")),
+				),
+			}))
+		})
+	})
 	When("require field missing", func() {
 		It("should raise validation error", func() {
 			err := validate.Struct(&HostPathDevicePluginConfig{})
@@ -57,3 +329,439 @@ var _ = Describe("Validation for HostPathDevicePluginConfig", func() {
 		})
 	})
 })
+
+var _ = Describe("Validation for Config", func() {
+	GetStructNamespace := func(e validator.FieldError) string { return e.StructNamespace() }
+	GetTag := func(e validator.FieldError) string { return e.Tag() }
+
+	When("valid config", func() {
+		It("should succeed", func() {
+			Expect(validate.Struct(&Config{
+				APIVersion: APIVersionV1Alpha1,
+				Kind:       Kind,
+				Resources:  []HostPathDevicePluginConfig{validResource("a"), validResource("b")},
+			})).ShouldNot(HaveOccurred())
+		})
+	})
+	When("no resources", func() {
+		It("should raise validation error", func() {
+			err := validate.Struct(&Config{APIVersion: APIVersionV1Alpha1, Kind: Kind})
+			Expect(err).Should(HaveOccurred())
+			Expect(err).To(MatchAllElementsWithIndex(IndexIdentity, Elements{
+				"0": SatisfyAll(
+					WithTransform(GetStructNamespace, Equal("Config.Resources")),
+					WithTransform(GetTag, Equal("required")),
+				),
+			}))
+		})
+	})
+	When("resources are duplicated", func() {
+		It("should raise validation error", func() {
+			dupResourceName := validResource("b")
+			dupResourceName.ResourceName = "test.org/a"
+			dupSocketName := validResource("c")
+			dupSocketName.SocketName = "a"
+
+			By("duplicated resourceName")
+			err := validate.Struct(&Config{
+				APIVersion: APIVersionV1Alpha1,
+				Kind:       Kind,
+				Resources:  []HostPathDevicePluginConfig{validResource("a"), dupResourceName},
+			})
+			Expect(err).To(MatchAllElementsWithIndex(IndexIdentity, Elements{
+				"0": SatisfyAll(
+					WithTransform(GetStructNamespace, Equal("Config.Resources[1].ResourceName")),
+					WithTransform(GetTag, Equal("unique")),
+				),
+			}))
+
+			By("duplicated socketName")
+			err = validate.Struct(&Config{
+				APIVersion: APIVersionV1Alpha1,
+				Kind:       Kind,
+				Resources:  []HostPathDevicePluginConfig{validResource("a"), dupSocketName},
+			})
+			Expect(err).To(MatchAllElementsWithIndex(IndexIdentity, Elements{
+				"0": SatisfyAll(
+					WithTransform(GetStructNamespace, Equal("Config.Resources[1].SocketName")),
+					WithTransform(GetTag, Equal("unique")),
+				),
+			}))
+		})
+	})
+})
+
+var _ = Describe("decodeConfig", func() {
+	When("apiVersion field exists", func() {
+		It("should decode a versioned config", func() {
+			config, format, err := decodeConfig([]byte(`{"apiVersion":"hostpath-device.k8s.io/v1alpha1","kind":"HostPathDevicePluginConfig","resources":[{"resourceName":"test.org/a"}]}`))
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(format).Should(Equal(formatV1Alpha1))
+			Expect(config.APIVersion).Should(Equal(APIVersionV1Alpha1))
+			Expect(config.Kind).Should(Equal(Kind))
+			Expect(config.Resources).Should(HaveLen(1))
+		})
+		It("should keep unsupported apiVersion to be validated", func() {
+			config, format, err := decodeConfig([]byte(`{"apiVersion":"hostpath-device.k8s.io/v2","resources":[]}`))
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(format).Should(Equal(formatV1Alpha1))
+			Expect(config.APIVersion).Should(Equal("hostpath-device.k8s.io/v2"))
+			Expect(config.Kind).Should(BeEmpty())
+		})
+	})
+	When("neither apiVersion nor kind exists", func() {
+		It("should convert a single resource", func() {
+			config, format, err := decodeConfig([]byte(`{"resourceName":"test.org/a","hostPath":{"path":"/mnt/a"}}`))
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(format).Should(Equal(formatLegacy))
+			Expect(config.APIVersion).Should(Equal(APIVersionV1Alpha1))
+			Expect(config.Kind).Should(Equal(Kind))
+			Expect(config.Resources).Should(HaveLen(1))
+			Expect(config.Resources[0].ResourceName).Should(Equal("test.org/a"))
+			Expect(config.Resources[0].HostPath.Path).Should(Equal("/mnt/a"))
+		})
+	})
+})
+
+var _ = Describe("ConvertLegacyConfig", func() {
+	It("should convert a single resource to a list of resources", func() {
+		resource := HostPathDevicePluginConfig{ResourceName: "test.org/a"}
+		Expect(ConvertLegacyConfig(resource)).Should(Equal(Config{
+			APIVersion: APIVersionV1Alpha1,
+			Kind:       Kind,
+			Resources:  []HostPathDevicePluginConfig{resource},
+		}))
+	})
+})
+
+var _ = Describe("LoadConfig", func() {
+	var dir string
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "config")
+		Expect(err).ShouldNot(HaveOccurred())
+	})
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).Should(Succeed())
+	})
+	load := func(content string) (Config, error) {
+		path := filepath.Join(dir, "config.yaml")
+		Expect(os.WriteFile(path, []byte(content), 0644)).Should(Succeed())
+		return LoadConfig(path, true)
+	}
+
+	It("should load a valid config with defaults", func() {
+		config, err := load(`
+resourceName: test.org/a
+socketName: a.sock
+numDevices: 1
+hostPath:
+  path: /mnt/a
+volumeMount:
+  mountPath: /mnt/a
+`)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(config.Resources).Should(HaveLen(1))
+		Expect(config.Resources[0].Injection).Should(Equal(InjectionWebhook))
+	})
+
+	It("should load a versioned config with defaults", func() {
+		config, err := load(`
+apiVersion: hostpath-device.k8s.io/v1alpha1
+kind: HostPathDevicePluginConfig
+resources:
+- resourceName: test.org/a
+  socketName: a.sock
+  numDevices: 1
+  hostPath:
+    path: /mnt/a
+  volumeMount:
+    mountPath: /mnt/a
+`)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(config.APIVersion).Should(Equal(APIVersionV1Alpha1))
+		Expect(config.Resources).Should(HaveLen(1))
+		Expect(config.Resources[0].Injection).Should(Equal(InjectionWebhook))
+	})
+
+	It("should return an error for invalid config", func() {
+		_, err := load(`
+resourceName: test.org/a
+socketName: a.sock
+numDevices: 0
+`)
+		Expect(err).Should(MatchError(ContainSubstring("invalid config")))
+
+		_, err = load(`resources: [`)
+		Expect(err).Should(MatchError(ContainSubstring("failed to parse config file")))
+
+		// a list of resources requires apiVersion and kind
+		_, err = load(`
+resources:
+- resourceName: test.org/a
+  socketName: a.sock
+  numDevices: 1
+`)
+		Expect(err).Should(MatchError(ContainSubstring("resources: unknown field")))
+
+		_, err = LoadConfig(filepath.Join(dir, "not-exist.yaml"), true)
+		Expect(err).Should(MatchError(ContainSubstring("failed to open config file")))
+	})
+})
+
+var _ = Describe("Errors of LoadConfig", func() {
+	var dir string
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "config")
+		Expect(err).ShouldNot(HaveOccurred())
+	})
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).Should(Succeed())
+	})
+	load := func(content string) error {
+		path := filepath.Join(dir, "config.yaml")
+		Expect(os.WriteFile(path, []byte(content), 0644)).Should(Succeed())
+		_, err := LoadConfig(path, true)
+		return err
+	}
+
+	It("should report all the validation errors with field paths", func() {
+		err := load(`
+apiVersion: hostpath-device.k8s.io/v1alpha1
+kind: HostPathDevicePluginConfig
+resources:
+- resourceName: test.org/a
+  socketName: a.sock
+  numDevices: 0
+  injection: unknown
+  hostPath:
+    type: Unknown
+  volumeMount:
+    mountPath: /mnt/a
+  probes:
+  - type: fileExists
+- resourceName: test.org/a
+  socketName: b.sock
+  numDevices: 1
+  exclusive: true
+  hostPath:
+    path: /mnt/b
+  volumeMount:
+    mountPath: /mnt/b
+  preStart:
+    mode: "0999"
+`)
+		Expect(ToFieldErrors(err)).Should(ConsistOf(
+			FieldError{Field: "resources[1].resourceName", Message: "must be unique across resources, but test.org/a is duplicated"},
+			FieldError{Field: "resources[0].hostPath.path", Message: "is required"},
+			FieldError{Field: "resources[0].hostPath.type", Message: "must be one of [DirectoryOrCreate, Directory, FileOrCreate, File, Socket, CharDevice, BlockDevice], but got Unknown"},
+			FieldError{Field: "resources[0].numDevices", Message: "must be at least 1"},
+			FieldError{Field: "resources[0].injection", Message: "must be one of [webhook, allocate, both, cdi], but got unknown"},
+			FieldError{Field: "resources[0].probes[0].file", Message: "is required when type is fileExists"},
+			FieldError{Field: "resources[1].preStart.mode", Message: `must be permission bits in octal like "0750", but got 0999`},
+			FieldError{Field: "resources[1].exclusive", Message: "requires injection: allocate"},
+		))
+	})
+
+	It("should report field paths without resources[0] for a single resource", func() {
+		err := load(`
+resourceName: test.org/a
+socketName: a.sock
+numDevices: 0
+hostPath:
+  path: /mnt/a
+volumeMount:
+  mountPath: /mnt/a
+`)
+		Expect(ToFieldErrors(err)).Should(Equal([]FieldError{
+			{Field: "numDevices", Message: "must be at least 1"},
+		}))
+		Expect(err).Should(MatchError("invalid config: numDevices: must be at least 1"))
+	})
+
+	It("should report templates referring to device ids which can't be rendered", func() {
+		err := load(`
+apiVersion: hostpath-device.k8s.io/v1alpha1
+kind: HostPathDevicePluginConfig
+resources:
+- resourceName: test.org/a
+  socketName: a.sock
+  numDevices: 1
+  hostPath:
+    path: /mnt/a
+  volumeMount:
+    mountPath: /mnt/a
+  envs:
+    DEVICE_ID: "{{ index .DeviceIDs 0 }}"
+    DATA_DIR: "{{ .MountPath }}"
+  annotations:
+    test.org/device-ids: "{{ range $.DeviceIDs }}{{ . }}{{ end }}"
+- resourceName: test.org/b
+  socketName: b.sock
+  numDevices: 1
+  injection: cdi
+  hostPath:
+    path: /mnt/b
+  volumeMount:
+    mountPath: /mnt/b
+  envs:
+    DEVICE_IDS: '{{ if .DeviceIDs }}{{ join .DeviceIDs "," }}{{ end }}'
+  annotations:
+    test.org/device-ids: '{{ join .DeviceIDs "," }}'
+- resourceName: test.org/c
+  socketName: c.sock
+  numDevices: 1
+  injection: both
+  hostPath:
+    path: /mnt/c
+  volumeMount:
+    mountPath: /mnt/c
+  envs:
+    DEVICE_IDS: '{{ join .DeviceIDs "," }}'
+`)
+		Expect(ToFieldErrors(err)).Should(ConsistOf(
+			FieldError{Field: "resources[0].envs[DEVICE_ID]", Message: "can't refer to .DeviceIDs in webhook injection mode because device IDs are known only in Allocate"},
+			FieldError{Field: "resources[0].annotations[test.org/device-ids]", Message: "can't refer to .DeviceIDs in webhook injection mode because device IDs are known only in Allocate"},
+			FieldError{Field: "resources[1].envs[DEVICE_IDS]", Message: "can't refer to .DeviceIDs in cdi injection mode because device IDs are known only in Allocate"},
+		))
+	})
+
+	It("should report unsupported apiVersion and kind", func() {
+		err := load(`
+apiVersion: hostpath-device.k8s.io/v2
+kind: Unknown
+resources:
+- resourceName: test.org/a
+  socketName: a.sock
+  numDevices: 1
+  hostPath:
+    path: /mnt/a
+  volumeMount:
+    mountPath: /mnt/a
+`)
+		Expect(ToFieldErrors(err)).Should(ConsistOf(
+			FieldError{Field: "apiVersion", Message: "must be one of [hostpath-device.k8s.io/v1alpha1], but got hostpath-device.k8s.io/v2"},
+			FieldError{Field: "kind", Message: "must be one of [HostPathDevicePluginConfig], but got Unknown"},
+		))
+
+		err = load(`
+apiVersion: hostpath-device.k8s.io/v1alpha1
+resources:
+- resourceName: test.org/a
+  socketName: a.sock
+  numDevices: 1
+  hostPath:
+    path: /mnt/a
+  volumeMount:
+    mountPath: /mnt/a
+`)
+		Expect(ToFieldErrors(err)).Should(Equal([]FieldError{{Field: "kind", Message: "is required"}}))
+	})
+
+	It("should report parse errors without field paths", func() {
+		err := load(`resources: [`)
+		Expect(ToFieldErrors(err)).Should(HaveLen(1))
+		Expect(ToFieldErrors(err)[0].Field).Should(BeEmpty())
+	})
+})
+
+var _ = Describe("PreStart.FileMode", func() {
+	for _, c := range []struct {
+		mode     string
+		expected os.FileMode
+	}{
+		{"0750", 0750},
+		{"2770", os.ModeSetgid | 0770},
+		{"1777", os.ModeSticky | 0777},
+		{"4755", os.ModeSetuid | 0755},
+	} {
+		c := c
+		It("should convert "+c.mode, func() {
+			Expect(PreStart{Mode: c.mode}.FileMode()).Should(Equal(c.expected))
+		})
+	}
+})
+
+var _ = Describe("setDefaults", func() {
+	It("should fill default values", func() {
+		c := HostPathDevicePluginConfig{
+			Probes: []Probe{
+				{Type: ProbeExec, Command: []string{"true"}},
+			},
+			DeviceNodes: []DeviceNode{
+				{HostPath: "/dev/fuse"},
+				{HostPath: "/dev/net/tun", ContainerPath: "/dev/tun", Permissions: "rw"},
+			},
+			GarbageCollection: &GarbageCollection{},
+		}
+		setDefaults(&c)
+		Expect(c.HealthCheckInterval).Should(Equal(defaultHealthCheckInterval))
+		Expect(c.Injection).Should(Equal(InjectionWebhook))
+		Expect(c.Probes[0].Timeout).Should(Equal(defaultProbeTimeout))
+		Expect(c.GarbageCollection).Should(Equal(&GarbageCollection{
+			Interval:           defaultGCInterval,
+			GracePeriod:        defaultGCGracePeriod,
+			PodResourcesSocket: defaultPodResourcesSocket,
+		}))
+		Expect(c.DeviceNodes).Should(Equal([]DeviceNode{
+			{HostPath: "/dev/fuse", ContainerPath: "/dev/fuse", Permissions: "rwm"},
+			{HostPath: "/dev/net/tun", ContainerPath: "/dev/tun", Permissions: "rw"},
+		}))
+	})
+})
+
+var _ = Describe("Rendering envs and annotations", func() {
+	c := HostPathDevicePluginConfig{
+		ResourceName: "test.org/test-resource",
+		HostPath: corev1.HostPathVolumeSource{
+			Path: "/mnt/hostpath",
+		},
+		VolumeMount: corev1.VolumeMount{
+			MountPath: "/data",
+		},
+		Envs: map[string]string{
+			"DATA_DIR":   "{{ .MountPath }}",
+			"DEVICE_IDS": `{{ join .DeviceIDs "," }}`,
+		},
+		Annotations: map[string]string{
+			"test.org/resource": "{{ .ResourceName }}={{ .HostPath }}",
+		},
+	}
+	It("should render templates with the allocated device ids", func() {
+		envs, err := c.RenderEnvs([]string{"0", "1"})
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(envs).Should(Equal(map[string]string{
+			"DATA_DIR":   "/data",
+			"DEVICE_IDS": "0,1",
+		}))
+
+		annotations, err := c.RenderAnnotations([]string{"0", "1"})
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(annotations).Should(Equal(map[string]string{
+			"test.org/resource": "test.org/test-resource=/mnt/hostpath",
+		}))
+	})
+	It("should skip templates referring to device ids before allocation", func() {
+		envs, err := c.RenderEnvs(nil)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(envs).Should(Equal(map[string]string{
+			"DATA_DIR": "/data",
+		}))
+	})
+	It("should return nil when no templates", func() {
+		Expect(HostPathDevicePluginConfig{}.RenderEnvs(nil)).Should(BeNil())
+	})
+})
+
+var _ = Describe("CDI", func() {
+	c := HostPathDevicePluginConfig{
+		ResourceName: "hostpath-device.k8s.io/sample.data",
+	}
+	It("should return valid CDI kind", func() {
+		Expect(c.CDIKind()).Should(Equal("hostpath-device.k8s.io/sample-data"))
+	})
+	It("should return CDI spec filename", func() {
+		Expect(c.CDISpecName()).Should(Equal("hostpath-device-k8s-io-sample-data.json"))
+	})
+})
@@ -0,0 +1,106 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/pkg/errors"
+)
+
+// FieldError is an error found in a config file
+type FieldError struct {
+	// Field is the path of the field in the config file(e.g. "resources[0].hostPath.path").
+	// This is empty when the error is not of a specific field(e.g. syntax errors).
+	Field string `json:"field,omitempty"`
+	// Message describes the error
+	Message string `json:"message"`
+}
+
+func (e FieldError) Error() string {
+	if e.Field == "" {
+		return e.Message
+	}
+	return e.Field + ": " + e.Message
+}
+
+// Errors is a list of all the errors found in a config file
+type Errors []FieldError
+
+func (errs Errors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, e := range errs {
+		msgs[i] = e.Error()
+	}
+	return "invalid config: " + strings.Join(msgs, "; ")
+}
+
+// ToFieldErrors returns FieldErrors of err returned by LoadConfig
+func ToFieldErrors(err error) []FieldError {
+	if err == nil {
+		return nil
+	}
+	var errs Errors
+	if errors.As(err, &errs) {
+		return errs
+	}
+	return []FieldError{{Message: err.Error()}}
+}
+
+// newErrors converts validation errors to Errors.  When single is true, the config file declares
+// a single resource at the top level.  So, "resources[0]." is trimmed from field paths.
+func newErrors(validationErrors validator.ValidationErrors, single bool) Errors {
+	errs := make(Errors, 0, len(validationErrors))
+	for _, fe := range validationErrors {
+		// Namespace() is like "Config.resources[0].hostPath.path"
+		_, field, _ := strings.Cut(fe.Namespace(), ".")
+		if single {
+			field = strings.TrimPrefix(field, "resources[0].")
+		}
+		errs = append(errs, FieldError{Field: field, Message: errorMessage(fe)})
+	}
+	return errs
+}
+
+// errorMessage returns a human readable message of the validation error
+func errorMessage(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "required_if":
+		field, value, _ := strings.Cut(fe.Param(), " ")
+		return fmt.Sprintf("is required when %s is %s", lowerFirst(field), value)
+	case "min":
+		if fe.Kind().String() == "slice" {
+			return fmt.Sprintf("must have at least %s items", fe.Param())
+		}
+		return fmt.Sprintf("must be at least %s", fe.Param())
+	case "oneof":
+		return fmt.Sprintf("must be one of [%s], but got %v", strings.ReplaceAll(fe.Param(), " ", ", "), fe.Value())
+	case "unique":
+		return fmt.Sprintf("must be unique across resources, but %v is duplicated", fe.Value())
+	case "glob":
+		return fmt.Sprintf("must be a valid glob pattern, but got %v", fe.Value())
+	case "template":
+		return fmt.Sprintf("must be a valid Go template, but got %v", fe.Value())
+	case "devicepermissions":
+		return fmt.Sprintf("must be a combination of r, w and m, but got %v", fe.Value())
+	case "filemode":
+		return fmt.Sprintf(`must be permission bits in octal like "0750", but got %v`, fe.Value())
+	case "injection":
+		return "requires injection: allocate"
+	case "exclusive":
+		return "requires exclusive: true"
+	case "deviceids":
+		return fmt.Sprintf("can't refer to .DeviceIDs in %s injection mode because device IDs are known only in Allocate", fe.Param())
+	default:
+		return fmt.Sprintf("failed on the '%s' validation", fe.Tag())
+	}
+}
+
+func lowerFirst(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToLower(s[:1]) + s[1:]
+}
@@ -0,0 +1,287 @@
+package config
+
+import (
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// SchemaID is the identifier of the JSON Schema of the config file
+	SchemaID = "https://github.com/everpeace/k8s-hostpath-device-plugin/config.schema.json"
+)
+
+// JSONSchema returns the JSON Schema(draft 2020-12) of the config file.  The schema is derived from
+// the yaml and validate tags of Config, plus constraints of the custom validators.
+func JSONSchema() map[string]interface{} {
+	defs := map[string]interface{}{
+		"HostPathVolumeSource": hostPathVolumeSourceSchema(),
+		"VolumeMount":          volumeMountSchema(),
+	}
+	config := schemaOf(reflect.TypeOf(Config{}), nil, defs)
+	resource := schemaOf(reflect.TypeOf(HostPathDevicePluginConfig{}), nil, defs)
+	addCrossFieldConstraints(defs)
+	// the deprecated unversioned config file is a single resource without apiVersion and kind
+	unversioned := map[string]interface{}{
+		"not": map[string]interface{}{"anyOf": []interface{}{
+			map[string]interface{}{"required": []string{"apiVersion"}},
+			map[string]interface{}{"required": []string{"kind"}},
+		}},
+	}
+	legacy := map[string]interface{}{"allOf": []interface{}{unversioned, resource}, "deprecated": true}
+
+	return map[string]interface{}{
+		"$schema": "https://json-schema.org/draft/2020-12/schema",
+		"$id":     SchemaID,
+		"title":   "k8s-hostpath-device-plugin config",
+		// hostpath-device.k8s.io/v1alpha1 or the deprecated unversioned format
+		"anyOf": []interface{}{config, legacy},
+		"$defs": defs,
+	}
+}
+
+// schemaOf returns the schema of t constrained by rules(validate tags).  Struct types are
+// registered to defs and referred by "$ref".
+func schemaOf(t reflect.Type, rules []string, defs map[string]interface{}) map[string]interface{} {
+	var schema map[string]interface{}
+	switch {
+	case t == reflect.TypeOf(time.Duration(0)):
+		return map[string]interface{}{"type": "integer", "minimum": 0, "description": "duration in nanoseconds"}
+	case t == reflect.TypeOf(corev1.HostPathVolumeSource{}):
+		return map[string]interface{}{"$ref": "#/$defs/HostPathVolumeSource"}
+	case t == reflect.TypeOf(corev1.VolumeMount{}):
+		return map[string]interface{}{"$ref": "#/$defs/VolumeMount"}
+	}
+
+	// rules after "dive" are applied to elements
+	var elemRules []string
+	for i, rule := range rules {
+		if rule == "dive" {
+			rules, elemRules = rules[:i], rules[i+1:]
+			break
+		}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return schemaOf(t.Elem(), rules, defs)
+	case reflect.Struct:
+		if _, ok := defs[t.Name()]; !ok {
+			// registered before generating properties for recursive types
+			defs[t.Name()] = nil
+			defs[t.Name()] = structSchema(t, defs)
+		}
+		return map[string]interface{}{"$ref": "#/$defs/" + t.Name()}
+	case reflect.Slice:
+		schema = map[string]interface{}{"type": "array", "items": schemaOf(t.Elem(), elemRules, defs)}
+	case reflect.Map:
+		schema = map[string]interface{}{"type": "object", "additionalProperties": schemaOf(t.Elem(), elemRules, defs)}
+	case reflect.String:
+		schema = map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		schema = map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		schema = map[string]interface{}{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		schema = map[string]interface{}{"type": "integer", "minimum": 0}
+	default:
+		schema = map[string]interface{}{}
+	}
+
+	for _, rule := range rules {
+		name, param, _ := strings.Cut(rule, "=")
+		switch name {
+		case "required":
+			if t.Kind() == reflect.Slice {
+				schema["minItems"] = 1
+			} else if t.Kind() == reflect.String {
+				schema["minLength"] = 1
+			}
+		case "min":
+			n, _ := strconv.Atoi(param)
+			switch t.Kind() {
+			case reflect.Slice:
+				schema["minItems"] = n
+			case reflect.String:
+				schema["minLength"] = n
+			default:
+				schema["minimum"] = n
+			}
+		case "oneof":
+			schema["enum"] = strings.Fields(param)
+		case "devicepermissions":
+			schema["enum"] = []string{"r", "w", "m", "rw", "wr", "rm", "mr", "wm", "mw", "rwm", "rmw", "wrm", "wmr", "mrw", "mwr"}
+		case "filemode":
+			pattern := fileModePattern.String()
+			if slices.Contains(rules, "omitempty") {
+				pattern = "^$|" + pattern
+			}
+			schema["pattern"] = pattern
+		case "glob":
+			schema["description"] = "glob pattern"
+		case "template":
+			schema["description"] = "Go template"
+		}
+	}
+	return schema
+}
+
+// structSchema returns the object schema of t.  Fields are named by yaml tags.
+func structSchema(t reflect.Type, defs map[string]interface{}) map[string]interface{} {
+	properties := map[string]interface{}{}
+	required := []string{}
+	var conditions []interface{}
+	fieldNames := map[string]string{}
+	for i := 0; i < t.NumField(); i++ {
+		fieldNames[t.Field(i).Name] = yamlName(t.Field(i))
+	}
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := yamlName(f)
+		var rules []string
+		if tag := f.Tag.Get("validate"); tag != "" {
+			rules = strings.Split(tag, ",")
+		}
+		properties[name] = schemaOf(f.Type, rules, defs)
+		for _, rule := range rules {
+			if rule == "dive" {
+				break
+			}
+			ruleName, param, _ := strings.Cut(rule, "=")
+			switch ruleName {
+			case "required":
+				required = append(required, name)
+			case "min":
+				// zero value of numbers fails "min" unless "omitempty"
+				if n, _ := strconv.Atoi(param); n > 0 && isNumber(f.Type) && !slices.Contains(rules, "omitempty") {
+					required = append(required, name)
+				}
+			case "required_if":
+				field, value, _ := strings.Cut(param, " ")
+				conditions = append(conditions, map[string]interface{}{
+					"if": map[string]interface{}{
+						"properties": map[string]interface{}{fieldNames[field]: map[string]interface{}{"const": value}},
+						"required":   []string{fieldNames[field]},
+					},
+					"then": map[string]interface{}{"required": []string{name}},
+				})
+			}
+		}
+	}
+
+	schema := map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+		// unknown fields are rejected by strict decoding
+		"additionalProperties": false,
+	}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	if len(conditions) > 0 {
+		schema["allOf"] = conditions
+	}
+	return schema
+}
+
+// addCrossFieldConstraints adds constraints validated by HostPathDevicePluginConfigValidation
+func addCrossFieldConstraints(defs map[string]interface{}) {
+	requiresExclusive := func(condition map[string]interface{}) map[string]interface{} {
+		return map[string]interface{}{
+			"if":   condition,
+			"then": map[string]interface{}{"properties": map[string]interface{}{"exclusive": map[string]interface{}{"const": true}}, "required": []string{"exclusive"}},
+		}
+	}
+	schema := defs["HostPathDevicePluginConfig"].(map[string]interface{})
+	conditions, _ := schema["allOf"].([]interface{})
+	schema["allOf"] = append(conditions,
+		map[string]interface{}{
+			"if": map[string]interface{}{
+				"properties": map[string]interface{}{"exclusive": map[string]interface{}{"const": true}},
+				"required":   []string{"exclusive"},
+			},
+			"then": map[string]interface{}{
+				"properties": map[string]interface{}{"injection": map[string]interface{}{"const": string(InjectionAllocate)}},
+				"required":   []string{"injection"},
+			},
+		},
+		requiresExclusive(map[string]interface{}{
+			"properties": map[string]interface{}{"preStart": map[string]interface{}{
+				"properties": map[string]interface{}{"wipe": map[string]interface{}{"const": true}},
+				"required":   []string{"wipe"},
+			}},
+			"required": []string{"preStart"},
+		}),
+		requiresExclusive(map[string]interface{}{
+			"properties": map[string]interface{}{"preStart": map[string]interface{}{
+				"anyOf": []interface{}{
+					map[string]interface{}{"required": []string{"uid"}},
+					map[string]interface{}{"required": []string{"gid"}},
+					map[string]interface{}{"required": []string{"mode"}},
+				},
+			}},
+			"required": []string{"preStart"},
+		}),
+		requiresExclusive(map[string]interface{}{"required": []string{"garbageCollection"}}),
+	)
+}
+
+// hostPathVolumeSourceSchema returns the schema of corev1.HostPathVolumeSource validated by HostPathVolumeValidation
+func hostPathVolumeSourceSchema() map[string]interface{} {
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"path": map[string]interface{}{"type": "string", "minLength": 1},
+			"type": map[string]interface{}{
+				"type": "string",
+				"enum": []string{"", "DirectoryOrCreate", "Directory", "FileOrCreate", "File", "Socket", "CharDevice", "BlockDevice"},
+			},
+		},
+		"required":             []string{"path"},
+		"additionalProperties": false,
+	}
+}
+
+// volumeMountSchema returns the schema of corev1.VolumeMount validated by VolumeMountValidation.  "name" is
+// accepted but ignored.
+func volumeMountSchema() map[string]interface{} {
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"name":              map[string]interface{}{"type": "string"},
+			"mountPath":         map[string]interface{}{"type": "string", "minLength": 1},
+			"readOnly":          map[string]interface{}{"type": "boolean"},
+			"recursiveReadOnly": map[string]interface{}{"type": "string", "enum": []string{"Disabled", "IfPossible", "Enabled"}},
+			"subPath":           map[string]interface{}{"type": "string"},
+			"subPathExpr":       map[string]interface{}{"type": "string"},
+			"mountPropagation":  map[string]interface{}{"type": "string", "enum": []string{"None", "HostToContainer", "Bidirectional"}},
+		},
+		"required":             []string{"mountPath"},
+		"additionalProperties": false,
+	}
+}
+
+// isNumber returns true when t is an integer type
+func isNumber(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return false
+}
+
+// yamlName returns the field name in the config file by the yaml tag, or the json tag for k8s types
+func yamlName(f reflect.StructField) string {
+	for _, key := range []string{"yaml", "json"} {
+		if name, _, _ := strings.Cut(f.Tag.Get(key), ","); name != "" {
+			return name
+		}
+	}
+	return f.Name
+}
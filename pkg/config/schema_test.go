@@ -0,0 +1,299 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"strconv"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// validateFileMode validates mode as PreStart.Mode.  "validate" is shadowed in the specs below.
+func validateFileMode(mode string) error {
+	return validate.Var(mode, "omitempty,filemode")
+}
+
+var _ = Describe("JSONSchema", func() {
+	var schema *jsonschema.Schema
+	BeforeEach(func() {
+		raw, err := json.Marshal(JSONSchema())
+		Expect(err).ShouldNot(HaveOccurred())
+		doc, err := jsonschema.UnmarshalJSON(strings.NewReader(string(raw)))
+		Expect(err).ShouldNot(HaveOccurred())
+		c := jsonschema.NewCompiler()
+		Expect(c.AddResource(SchemaID, doc)).Should(Succeed())
+		schema, err = c.Compile(SchemaID)
+		Expect(err).ShouldNot(HaveOccurred())
+	})
+	validate := func(content string) error {
+		raw, err := yaml.ToJSON([]byte(content))
+		Expect(err).ShouldNot(HaveOccurred())
+		doc, err := jsonschema.UnmarshalJSON(strings.NewReader(string(raw)))
+		Expect(err).ShouldNot(HaveOccurred())
+		return schema.Validate(doc)
+	}
+
+	It("should accept the example config", func() {
+		content, err := os.ReadFile("../../example/config.yaml")
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(validate(string(content))).Should(Succeed())
+	})
+
+	It("should accept a versioned config", func() {
+		Expect(validate(`
+apiVersion: hostpath-device.k8s.io/v1alpha1
+kind: HostPathDevicePluginConfig
+resources:
+- resourceName: test.org/a
+  socketName: a.sock
+  numDevices: 1
+  hostPath:
+    path: /mnt/a
+  volumeMount:
+    mountPath: /mnt/a
+`)).Should(Succeed())
+	})
+
+	It("should accept all the fields of a resource", func() {
+		Expect(validate(`
+apiVersion: hostpath-device.k8s.io/v1alpha1
+kind: HostPathDevicePluginConfig
+resources:
+- resourceName: test.org/a
+  socketName: a.sock
+  numDevices: 2
+  devicePaths: /mnt/a*
+  injection: allocate
+  exclusive: true
+  allocationPolicy: pack
+  hostPath:
+    path: /mnt/a
+    type: DirectoryOrCreate
+  volumeMount:
+    name: ignored
+    mountPath: /mnt/a
+    mountPropagation: HostToContainer
+  deviceNodes:
+  - hostPath: /dev/fuse
+    permissions: rw
+  envs:
+    A: "{{ .HostPath }}"
+  probes:
+  - type: fsType
+    fsTypes: [xfs]
+  preStart:
+    uid: 1000
+    mode: "0750"
+    wipe: true
+  garbageCollection:
+    interval: 60000000000
+`)).Should(Succeed())
+	})
+
+	invalidConfigs := []struct {
+		name    string
+		content string
+	}{
+		{"empty resources", `resources: []`},
+		{"list of resources without apiVersion", `
+resources:
+- resourceName: test.org/a
+  socketName: a.sock
+  numDevices: 1
+  hostPath:
+    path: /mnt/a
+  volumeMount:
+    mountPath: /mnt/a
+`},
+		{"unknown field", `
+resourceName: test.org/a
+socketName: a.sock
+numDevices: 1
+healthcheckInterval: 1000000000
+hostPath:
+  path: /mnt/a
+volumeMount:
+  mountPath: /mnt/a
+`},
+		{"unsupported apiVersion", `
+apiVersion: hostpath-device.k8s.io/v2
+kind: HostPathDevicePluginConfig
+resources:
+- resourceName: test.org/a
+  socketName: a.sock
+  numDevices: 1
+  hostPath:
+    path: /mnt/a
+  volumeMount:
+    mountPath: /mnt/a
+`},
+		{"apiVersion without kind", `
+apiVersion: hostpath-device.k8s.io/v1alpha1
+resourceName: test.org/a
+socketName: a.sock
+numDevices: 1
+hostPath:
+  path: /mnt/a
+volumeMount:
+  mountPath: /mnt/a
+`},
+		{"missing numDevices", `
+resourceName: test.org/a
+socketName: a.sock
+hostPath:
+  path: /mnt/a
+volumeMount:
+  mountPath: /mnt/a
+`},
+		{"unknown hostPath type", `
+resourceName: test.org/a
+socketName: a.sock
+numDevices: 1
+hostPath:
+  path: /mnt/a
+  type: Unknown
+volumeMount:
+  mountPath: /mnt/a
+`},
+		{"unknown field of volumeMount", `
+resourceName: test.org/a
+socketName: a.sock
+numDevices: 1
+hostPath:
+  path: /mnt/a
+volumeMount:
+  mountPath: /mnt/a
+  readonly: true
+`},
+		{"unknown field of hostPath", `
+resourceName: test.org/a
+socketName: a.sock
+numDevices: 1
+hostPath:
+  path: /mnt/a
+  kind: Directory
+volumeMount:
+  mountPath: /mnt/a
+`},
+		{"negative healthCheckInterval", `
+resourceName: test.org/a
+socketName: a.sock
+numDevices: 1
+healthCheckInterval: -1
+hostPath:
+  path: /mnt/a
+volumeMount:
+  mountPath: /mnt/a
+`},
+		{"missing mountPath", `
+resourceName: test.org/a
+socketName: a.sock
+numDevices: 1
+hostPath:
+  path: /mnt/a
+volumeMount:
+  readOnly: true
+`},
+		{"invalid device permissions", `
+resourceName: test.org/a
+socketName: a.sock
+numDevices: 1
+hostPath:
+  path: /mnt/a
+volumeMount:
+  mountPath: /mnt/a
+deviceNodes:
+- hostPath: /dev/fuse
+  permissions: rr
+`},
+		{"missing fsTypes of fsType probe", `
+resourceName: test.org/a
+socketName: a.sock
+numDevices: 1
+hostPath:
+  path: /mnt/a
+volumeMount:
+  mountPath: /mnt/a
+probes:
+- type: fsType
+`},
+		{"exclusive without allocate injection", `
+resourceName: test.org/a
+socketName: a.sock
+numDevices: 1
+exclusive: true
+hostPath:
+  path: /mnt/a
+volumeMount:
+  mountPath: /mnt/a
+`},
+		{"preStart mode without exclusive", `
+resourceName: test.org/a
+socketName: a.sock
+numDevices: 1
+injection: allocate
+hostPath:
+  path: /mnt/a
+volumeMount:
+  mountPath: /mnt/a
+preStart:
+  mode: "0750"
+`},
+		{"garbageCollection without exclusive", `
+resourceName: test.org/a
+socketName: a.sock
+numDevices: 1
+injection: allocate
+hostPath:
+  path: /mnt/a
+volumeMount:
+  mountPath: /mnt/a
+garbageCollection:
+  dryRun: true
+`},
+		{"duration in string", `
+resourceName: test.org/a
+socketName: a.sock
+numDevices: 1
+healthCheckInterval: 10s
+hostPath:
+  path: /mnt/a
+volumeMount:
+  mountPath: /mnt/a
+`},
+	}
+	for _, c := range invalidConfigs {
+		c := c
+		It("should reject "+c.name, func() {
+			Expect(validate(c.content)).ShouldNot(Succeed())
+		})
+	}
+
+	for _, mode := range []string{"750", "0750", "2770", "07777", "7", "00750", "17777", "0789", "+750", "0o750", ""} {
+		mode := mode
+		It("should agree with the validator on file mode "+strconv.Quote(mode), func() {
+			err := validate(`
+resourceName: test.org/a
+socketName: a.sock
+numDevices: 1
+injection: allocate
+exclusive: true
+hostPath:
+  path: /mnt/a
+volumeMount:
+  mountPath: /mnt/a
+preStart:
+  mode: ` + strconv.Quote(mode) + `
+`)
+			if validateFileMode(mode) == nil {
+				Expect(err).ShouldNot(HaveOccurred())
+			} else {
+				Expect(err).Should(HaveOccurred())
+			}
+		})
+	}
+})
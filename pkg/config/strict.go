@@ -0,0 +1,129 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+
+	"github.com/pkg/errors"
+	yamlv3 "gopkg.in/yaml.v3"
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// checkSingleDocument returns an error when decoder has documents other than the one already decoded.
+// Empty documents(e.g. a trailing "---") are ignored.
+func checkSingleDocument(decoder *yaml.YAMLOrJSONDecoder) error {
+	documents := 1
+	for {
+		var raw json.RawMessage
+		err := decoder.Decode(&raw)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if len(raw) > 0 && string(raw) != "null" {
+			documents++
+		}
+	}
+	if documents > 1 {
+		return fmt.Errorf("config file must have a single document, but found %d documents", documents)
+	}
+	return nil
+}
+
+// unknownFields returns errors of fields in the config file which are not defined in the format.
+// Each error has the line of the field.
+func unknownFields(data []byte, format configFormat) (Errors, error) {
+	var node yamlv3.Node
+	decoder := yamlv3.NewDecoder(bytes.NewReader(data))
+	for node.Kind == 0 || isEmptyDocument(&node) {
+		node = yamlv3.Node{}
+		if err := decoder.Decode(&node); err != nil {
+			if err == io.EOF {
+				return nil, nil
+			}
+			return nil, errors.Wrap(err, "failed to parse config file")
+		}
+	}
+
+	t := reflect.TypeOf(Config{})
+	if format == formatLegacy {
+		t = reflect.TypeOf(HostPathDevicePluginConfig{})
+	}
+	return unknownFieldsOf(&node, t, ""), nil
+}
+
+// unknownFieldsOf returns errors of fields in node which t doesn't have.  path is the field path of node.
+// Type mismatches are ignored because they are reported on decoding.
+func unknownFieldsOf(node *yamlv3.Node, t reflect.Type, path string) Errors {
+	for node.Kind == yamlv3.DocumentNode || node.Kind == yamlv3.AliasNode {
+		if node.Kind == yamlv3.AliasNode {
+			node = node.Alias
+		} else if len(node.Content) > 0 {
+			node = node.Content[0]
+		} else {
+			return nil
+		}
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	var errs Errors
+	switch {
+	case t.Kind() == reflect.Struct && node.Kind == yamlv3.MappingNode:
+		fields := map[string]reflect.StructField{}
+		for i := 0; i < t.NumField(); i++ {
+			if name := yamlName(t.Field(i)); name != "-" {
+				fields[name] = t.Field(i)
+			}
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			fieldPath := joinFieldPath(path, key.Value)
+			f, ok := fields[key.Value]
+			if !ok {
+				errs = append(errs, FieldError{Field: fieldPath, Message: unknownFieldMessage(key, fields)})
+				continue
+			}
+			errs = append(errs, unknownFieldsOf(value, f.Type, fieldPath)...)
+		}
+	case t.Kind() == reflect.Map && node.Kind == yamlv3.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			errs = append(errs, unknownFieldsOf(node.Content[i+1], t.Elem(), joinFieldPath(path, node.Content[i].Value))...)
+		}
+	case t.Kind() == reflect.Slice && node.Kind == yamlv3.SequenceNode:
+		for i, n := range node.Content {
+			errs = append(errs, unknownFieldsOf(n, t.Elem(), fmt.Sprintf("%s[%d]", path, i))...)
+		}
+	}
+	return errs
+}
+
+// unknownFieldMessage returns the message of the unknown field key.  It suggests a field differing
+// only in case because such typos are silently accepted when not strict.
+func unknownFieldMessage(key *yamlv3.Node, fields map[string]reflect.StructField) string {
+	msg := fmt.Sprintf("unknown field at line %d", key.Line)
+	for name := range fields {
+		if strings.EqualFold(name, key.Value) {
+			return fmt.Sprintf("%s, did you mean %s?", msg, name)
+		}
+	}
+	return msg
+}
+
+func isEmptyDocument(node *yamlv3.Node) bool {
+	return node.Kind == yamlv3.DocumentNode && (len(node.Content) == 0 || node.Content[0].Tag == "!!null")
+}
+
+func joinFieldPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
@@ -0,0 +1,129 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Strict decoding", func() {
+	var dir string
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "config")
+		Expect(err).ShouldNot(HaveOccurred())
+	})
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).Should(Succeed())
+	})
+	load := func(content string, strict bool) (Config, error) {
+		path := filepath.Join(dir, "config.yaml")
+		Expect(os.WriteFile(path, []byte(content), 0644)).Should(Succeed())
+		return LoadConfig(path, strict)
+	}
+
+	When("strict", func() {
+		It("should report unknown fields with their lines", func() {
+			_, err := load(`apiVersion: hostpath-device.k8s.io/v1alpha1
+kind: HostPathDevicePluginConfig
+resources:
+- resourceName: test.org/a
+  socketName: a.sock
+  numDevices: 1
+  healthcheckInterval: 1000000000
+  hostPath:
+    path: /mnt/a
+    typo: Directory
+  volumeMount:
+    name: ignored
+    mountPath: /mnt/a
+  envs:
+    ANY_NAME: "{{ .HostPath }}"
+  probes:
+  - type: exec
+    command: ["true"]
+    tiemout: 1000000000
+unknown: true
+`, true)
+			Expect(ToFieldErrors(err)).Should(ConsistOf(
+				FieldError{Field: "resources[0].healthcheckInterval", Message: "unknown field at line 7, did you mean healthCheckInterval?"},
+				FieldError{Field: "resources[0].hostPath.typo", Message: "unknown field at line 10"},
+				FieldError{Field: "resources[0].probes[0].tiemout", Message: "unknown field at line 19"},
+				FieldError{Field: "unknown", Message: "unknown field at line 20"},
+			))
+		})
+
+		It("should report unknown fields with validation errors of a single resource", func() {
+			_, err := load(`resourceName: test.org/a
+socketName: a.sock
+numDevice: 1
+hostPath:
+  path: /mnt/a
+volumeMount:
+  mountPath: /mnt/a
+`, true)
+			Expect(ToFieldErrors(err)).Should(Equal([]FieldError{
+				{Field: "numDevice", Message: "unknown field at line 3"},
+				{Field: "numDevices", Message: "must be at least 1"},
+			}))
+		})
+
+		It("should reject multiple documents", func() {
+			_, err := load(`resourceName: test.org/a
+---
+resourceName: test.org/b
+`, true)
+			Expect(err).Should(MatchError("failed to parse config file: config file must have a single document, but found 2 documents"))
+		})
+
+		It("should accept empty documents", func() {
+			config, err := load(`---
+resourceName: test.org/a
+socketName: a.sock
+numDevices: 1
+hostPath:
+  path: /mnt/a
+volumeMount:
+  mountPath: /mnt/a
+---
+`, true)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(config.Resources).Should(HaveLen(1))
+		})
+
+		It("should accept JSON", func() {
+			_, err := load(`{
+  "resourceName": "test.org/a",
+  "socketName": "a.sock",
+  "numDevices": 1,
+  "hostPath": {"path": "/mnt/a"},
+  "volumeMount": {"mountPath": "/mnt/a"},
+  "numDevice": 1
+}`, true)
+			Expect(ToFieldErrors(err)).Should(Equal([]FieldError{
+				{Field: "numDevice", Message: "unknown field at line 7"},
+			}))
+		})
+	})
+
+	When("not strict", func() {
+		It("should ignore unknown fields and documents other than the first one", func() {
+			config, err := load(`resourceName: test.org/a
+socketName: a.sock
+numDevices: 1
+unknown: true
+hostPath:
+  path: /mnt/a
+volumeMount:
+  mountPath: /mnt/a
+---
+resourceName: test.org/b
+`, false)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(config.Resources).Should(HaveLen(1))
+			Expect(config.Resources[0].ResourceName).Should(Equal("test.org/a"))
+		})
+	})
+})
@@ -0,0 +1,65 @@
+package config
+
+import (
+	"encoding/json"
+)
+
+const (
+	// GroupName is the API group of the config file
+	GroupName = "hostpath-device.k8s.io"
+	// APIVersionV1Alpha1 is the apiVersion of the config file in v1alpha1
+	APIVersionV1Alpha1 = GroupName + "/v1alpha1"
+	// Kind is the kind of the config file
+	Kind = "HostPathDevicePluginConfig"
+)
+
+// configFormat is the format which a config file is written in
+type configFormat int
+
+const (
+	// formatV1Alpha1 is the config file with apiVersion hostpath-device.k8s.io/v1alpha1
+	formatV1Alpha1 configFormat = iota
+	// formatLegacy is the unversioned config file with a single resource at the top level
+	formatLegacy
+)
+
+// ConvertLegacyConfig converts the deprecated unversioned config file, which has a single resource at the
+// top level, to hostpath-device.k8s.io/v1alpha1
+func ConvertLegacyConfig(in HostPathDevicePluginConfig) Config {
+	return Config{
+		APIVersion: APIVersionV1Alpha1,
+		Kind:       Kind,
+		Resources:  []HostPathDevicePluginConfig{in},
+	}
+}
+
+// SetDefaults sets default values to unset fields of all the resources
+func SetDefaults(c *Config) {
+	for i := range c.Resources {
+		setDefaults(&c.Resources[i])
+	}
+}
+
+// decodeConfig decodes raw into Config.  When raw has neither "apiVersion" nor "kind" field, raw is
+// decoded as a single resource and converted.  apiVersion and kind of versioned ones are checked by validation.
+func decodeConfig(raw json.RawMessage) (Config, configFormat, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return Config{}, formatV1Alpha1, err
+	}
+	_, hasAPIVersion := fields["apiVersion"]
+	_, hasKind := fields["kind"]
+	if hasAPIVersion || hasKind {
+		var config Config
+		if err := json.Unmarshal(raw, &config); err != nil {
+			return Config{}, formatV1Alpha1, err
+		}
+		return config, formatV1Alpha1, nil
+	}
+
+	var legacy HostPathDevicePluginConfig
+	if err := json.Unmarshal(raw, &legacy); err != nil {
+		return Config{}, formatLegacy, err
+	}
+	return ConvertLegacyConfig(legacy), formatLegacy, nil
+}
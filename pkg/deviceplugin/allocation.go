@@ -0,0 +1,138 @@
+package deviceplugin
+
+import (
+	"slices"
+	"time"
+
+	"github.com/everpeace/k8s-hostpath-device-plugin/pkg/config"
+	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
+)
+
+// deviceGroup is a group of available devices backed by the same path
+type deviceGroup struct {
+	path string
+	ids  []string
+	// preferred is true when the group has devices which must be included
+	preferred bool
+}
+
+// recordAllocation records the time when deviceIDs are allocated for AllocationPolicyLRU
+func (m *HostPathDevicePlugin) recordAllocation(deviceIDs []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	for _, id := range deviceIDs {
+		m.allocatedAt[id] = now
+	}
+}
+
+// preferredAllocation returns AllocationSize device IDs preferred in AllocationPolicy.  Devices in
+// MustIncludeDeviceIDs are always included.  The rest are picked from AvailableDeviceIDs.
+func (m *HostPathDevicePlugin) preferredAllocation(req *pluginapi.ContainerPreferredAllocationRequest) []string {
+	size := int(req.GetAllocationSize())
+	allocation := slices.Clone(req.GetMustIncludeDeviceIDs())
+	if len(allocation) >= size {
+		return allocation
+	}
+
+	m.mu.Lock()
+	devs := make([]hostPathDevice, 0, len(m.devs))
+	for _, dev := range m.devs {
+		devs = append(devs, *dev)
+	}
+	allocatedAt := make(map[string]time.Time, len(m.allocatedAt))
+	for id, t := range m.allocatedAt {
+		allocatedAt[id] = t
+	}
+	m.mu.Unlock()
+
+	// candidates and groups follow the order of devices
+	var candidates []string
+	var groups []*deviceGroup
+	groupOf := map[string]*deviceGroup{}
+	for _, dev := range devs {
+		g, ok := groupOf[dev.path]
+		if !ok {
+			g = &deviceGroup{path: dev.path}
+			groupOf[dev.path] = g
+			groups = append(groups, g)
+		}
+		if slices.Contains(allocation, dev.ID) {
+			g.preferred = true
+			continue
+		}
+		if slices.Contains(req.GetAvailableDeviceIDs(), dev.ID) {
+			g.ids = append(g.ids, dev.ID)
+			candidates = append(candidates, dev.ID)
+		}
+	}
+	need := size - len(allocation)
+
+	switch m.config.AllocationPolicy {
+	case config.AllocationPolicyPack:
+		// groups of must-include devices first, then the smallest group which can satisfy the
+		// request, then larger groups first so that the allocation spans fewer paths
+		slices.SortStableFunc(groups, func(a, b *deviceGroup) int {
+			if a.preferred != b.preferred {
+				return boolCmp(b.preferred, a.preferred)
+			}
+			aFits, bFits := len(a.ids) >= need, len(b.ids) >= need
+			if aFits != bFits {
+				return boolCmp(bFits, aFits)
+			}
+			if aFits {
+				return len(a.ids) - len(b.ids)
+			}
+			return len(b.ids) - len(a.ids)
+		})
+		for _, g := range groups {
+			allocation = append(allocation, g.ids...)
+		}
+	case config.AllocationPolicySpread:
+		// round robin over groups, starting from groups without must-include devices and
+		// with more available devices
+		slices.SortStableFunc(groups, func(a, b *deviceGroup) int {
+			if a.preferred != b.preferred {
+				return boolCmp(a.preferred, b.preferred)
+			}
+			return len(b.ids) - len(a.ids)
+		})
+		for i := 0; len(allocation) < size; i++ {
+			picked := false
+			for _, g := range groups {
+				if i < len(g.ids) {
+					allocation = append(allocation, g.ids[i])
+					picked = true
+				}
+			}
+			if !picked {
+				break
+			}
+		}
+	case config.AllocationPolicyLRU:
+		// devices never allocated are the least recently used
+		slices.SortStableFunc(candidates, func(a, b string) int {
+			return allocatedAt[a].Compare(allocatedAt[b])
+		})
+		allocation = append(allocation, candidates...)
+	default:
+		allocation = append(allocation, candidates...)
+	}
+
+	if len(allocation) > size {
+		allocation = allocation[:size]
+	}
+	return allocation
+}
+
+// boolCmp compares booleans as false < true
+func boolCmp(a, b bool) int {
+	switch {
+	case a == b:
+		return 0
+	case a:
+		return 1
+	default:
+		return -1
+	}
+}
@@ -0,0 +1,119 @@
+package deviceplugin
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/everpeace/k8s-hostpath-device-plugin/pkg/config"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
+)
+
+var _ = Describe("GetPreferredAllocation", func() {
+	var hostPath string
+	var disks []string
+	BeforeEach(func() {
+		var err error
+		hostPath, err = os.MkdirTemp("", "hostpath")
+		Expect(err).ShouldNot(HaveOccurred())
+		disks = nil
+		for _, disk := range []string{"disk1", "disk2", "disk3"} {
+			disks = append(disks, filepath.Join(hostPath, disk))
+			Expect(os.Mkdir(filepath.Join(hostPath, disk), 0755)).Should(Succeed())
+		}
+	})
+	AfterEach(func() {
+		Expect(os.RemoveAll(hostPath)).Should(Succeed())
+	})
+
+	newDevicePlugin := func(policy config.AllocationPolicy) *HostPathDevicePlugin {
+		dp, err := NewHostPathDevicePlugin(config.HostPathDevicePluginConfig{
+			ResourceName:     "test.org/test-resource",
+			HostPath:         corev1.HostPathVolumeSource{Path: hostPath},
+			DevicePaths:      filepath.Join(hostPath, "disk*"),
+			NumDevices:       2,
+			AllocationPolicy: policy,
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+		return dp
+	}
+	// id returns the ID of i-th device of disk-th disk
+	id := func(dp *HostPathDevicePlugin, disk, i int) string {
+		return dp.deviceID(disks[disk], i, 0)
+	}
+	allIDs := func(dp *HostPathDevicePlugin) []string {
+		ids := []string{}
+		for _, dev := range dp.devices() {
+			ids = append(ids, dev.ID)
+		}
+		return ids
+	}
+	without := func(ids []string, excluded ...string) []string {
+		remaining := []string{}
+		for _, id := range ids {
+			if !slices.Contains(excluded, id) {
+				remaining = append(remaining, id)
+			}
+		}
+		return remaining
+	}
+	preferred := func(dp *HostPathDevicePlugin, available, mustInclude []string, size int32) []string {
+		resp, err := dp.GetPreferredAllocation(context.Background(), &pluginapi.PreferredAllocationRequest{
+			ContainerRequests: []*pluginapi.ContainerPreferredAllocationRequest{{
+				AvailableDeviceIDs:   available,
+				MustIncludeDeviceIDs: mustInclude,
+				AllocationSize:       size,
+			}},
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+		return resp.ContainerResponses[0].DeviceIDs
+	}
+
+	It("should advertise preferred allocation only when allocationPolicy is set", func() {
+		opts, err := newDevicePlugin("").GetDevicePluginOptions(context.Background(), &pluginapi.Empty{})
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(opts.GetPreferredAllocationAvailable).Should(BeFalse())
+
+		opts, err = newDevicePlugin(config.AllocationPolicyPack).GetDevicePluginOptions(context.Background(), &pluginapi.Empty{})
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(opts.GetPreferredAllocationAvailable).Should(BeTrue())
+	})
+
+	It("should pack devices onto the same path", func() {
+		dp := newDevicePlugin(config.AllocationPolicyPack)
+		Expect(preferred(dp, allIDs(dp), nil, 2)).Should(Equal([]string{id(dp, 0, 0), id(dp, 0, 1)}))
+
+		// disk1 has only 1 available device
+		available := without(allIDs(dp), id(dp, 0, 0))
+		Expect(preferred(dp, available, nil, 1)).Should(Equal([]string{id(dp, 0, 1)}))
+		Expect(preferred(dp, available, nil, 2)).Should(Equal([]string{id(dp, 1, 0), id(dp, 1, 1)}))
+		Expect(preferred(dp, available, nil, 3)).Should(Equal([]string{id(dp, 1, 0), id(dp, 1, 1), id(dp, 2, 0)}))
+
+		Expect(preferred(dp, allIDs(dp), []string{id(dp, 2, 1)}, 2)).Should(Equal([]string{id(dp, 2, 1), id(dp, 2, 0)}))
+	})
+
+	It("should spread devices across paths", func() {
+		dp := newDevicePlugin(config.AllocationPolicySpread)
+		Expect(preferred(dp, allIDs(dp), nil, 3)).Should(Equal([]string{id(dp, 0, 0), id(dp, 1, 0), id(dp, 2, 0)}))
+		Expect(preferred(dp, allIDs(dp), nil, 4)).Should(Equal([]string{id(dp, 0, 0), id(dp, 1, 0), id(dp, 2, 0), id(dp, 0, 1)}))
+
+		Expect(preferred(dp, allIDs(dp), []string{id(dp, 0, 1)}, 2)).Should(Equal([]string{id(dp, 0, 1), id(dp, 1, 0)}))
+	})
+
+	It("should prefer least recently allocated devices", func() {
+		dp := newDevicePlugin(config.AllocationPolicyLRU)
+		_, err := dp.Allocate(context.Background(), &pluginapi.AllocateRequest{
+			ContainerRequests: []*pluginapi.ContainerAllocateRequest{{DevicesIDs: []string{id(dp, 0, 0), id(dp, 0, 1)}}},
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(preferred(dp, allIDs(dp), nil, 2)).Should(Equal([]string{id(dp, 1, 0), id(dp, 1, 1)}))
+
+		dp.allocatedAt[id(dp, 0, 0)] = dp.allocatedAt[id(dp, 0, 1)].Add(time.Second)
+		Expect(preferred(dp, []string{id(dp, 0, 0), id(dp, 0, 1)}, nil, 1)).Should(Equal([]string{id(dp, 0, 1)}))
+	})
+})
@@ -0,0 +1,84 @@
+package deviceplugin
+
+import (
+	"maps"
+	"slices"
+
+	"github.com/everpeace/k8s-hostpath-device-plugin/pkg/cdi"
+	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
+)
+
+const (
+	// cdiDeviceName is the name of the single CDI device which all the device IDs refer to
+	cdiDeviceName = "hostpath"
+)
+
+// cdiSpec returns the CDI spec which declares the mount, device nodes and envs of the resource
+func (m *HostPathDevicePlugin) cdiSpec() (*cdi.Spec, error) {
+	edits := cdi.ContainerEdits{}
+
+	mountOptions := []string{"rbind", "rw"}
+	if m.config.VolumeMount.ReadOnly {
+		mountOptions = []string{"rbind", "ro"}
+	}
+	edits.Mounts = append(edits.Mounts, &cdi.Mount{
+		HostPath:      m.config.HostPath.Path,
+		ContainerPath: m.config.VolumeMount.MountPath,
+		Type:          "bind",
+		Options:       mountOptions,
+	})
+
+	for _, node := range m.config.DeviceNodes {
+		edits.DeviceNodes = append(edits.DeviceNodes, &cdi.DeviceNode{
+			Path:        node.ContainerPath,
+			HostPath:    node.HostPath,
+			Permissions: node.Permissions,
+		})
+	}
+
+	// CDI spec is shared by all the device IDs. So, envs can't refer to the allocated device IDs.
+	envs, err := m.config.RenderEnvs(nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range slices.Sorted(maps.Keys(envs)) {
+		edits.Env = append(edits.Env, name+"="+envs[name])
+	}
+
+	return &cdi.Spec{
+		Version: cdi.Version,
+		Kind:    m.config.CDIKind(),
+		Devices: []cdi.Device{{
+			Name:           cdiDeviceName,
+			ContainerEdits: edits,
+		}},
+	}, nil
+}
+
+// syncCDISpec writes the CDI spec when health is Healthy, otherwise removes it
+// so that container runtimes never inject unhealthy host path.
+func (m *HostPathDevicePlugin) syncCDISpec(health string) error {
+	if !m.config.InjectsByCDI() {
+		return nil
+	}
+
+	logger := m.logger.With().Str("CDISpec", m.config.CDISpecDir+"/"+m.config.CDISpecName()).Logger()
+	if health != pluginapi.Healthy {
+		logger.Info().Str("Health", health).Msg("Removing CDI spec")
+		return cdi.RemoveSpec(m.config.CDISpecDir, m.config.CDISpecName())
+	}
+
+	spec, err := m.cdiSpec()
+	if err != nil {
+		return err
+	}
+	logger.Info().Msg("Writing CDI spec")
+	return cdi.WriteSpec(m.config.CDISpecDir, m.config.CDISpecName(), spec)
+}
+
+func (m *HostPathDevicePlugin) removeCDISpec() error {
+	if !m.config.InjectsByCDI() {
+		return nil
+	}
+	return cdi.RemoveSpec(m.config.CDISpecDir, m.config.CDISpecName())
+}
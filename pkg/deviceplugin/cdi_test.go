@@ -0,0 +1,95 @@
+package deviceplugin
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	"github.com/everpeace/k8s-hostpath-device-plugin/pkg/cdi"
+	"github.com/everpeace/k8s-hostpath-device-plugin/pkg/config"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
+)
+
+var _ = Describe("CDI injection mode", func() {
+	var hostPath, specDir string
+	var dp *HostPathDevicePlugin
+	BeforeEach(func() {
+		var err error
+		hostPath, err = os.MkdirTemp("", "hostpath")
+		Expect(err).ShouldNot(HaveOccurred())
+		specDir = filepath.Join(hostPath, "cdi")
+		dp, err = NewHostPathDevicePlugin(config.HostPathDevicePluginConfig{
+			ResourceName: "test.org/test.resource",
+			HostPath:     corev1.HostPathVolumeSource{Path: hostPath},
+			VolumeMount:  corev1.VolumeMount{MountPath: "/data", ReadOnly: true},
+			NumDevices:   2,
+			Injection:    config.InjectionCDI,
+			CDISpecDir:   specDir,
+			DeviceNodes:  []config.DeviceNode{{HostPath: "/dev/fuse", ContainerPath: "/dev/fuse", Permissions: "rw"}},
+			Envs:         map[string]string{"B": "{{ .MountPath }}", "A": "{{ .ResourceName }}"},
+			Annotations:  map[string]string{"test.org/device-ids": `{{ join .DeviceIDs "," }}`},
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+	})
+	AfterEach(func() {
+		Expect(os.RemoveAll(hostPath)).Should(Succeed())
+	})
+	readSpec := func() (*cdi.Spec, error) {
+		b, err := os.ReadFile(filepath.Join(specDir, "test-org-test-resource.json"))
+		if err != nil {
+			return nil, err
+		}
+		var spec cdi.Spec
+		return &spec, json.Unmarshal(b, &spec)
+	}
+
+	It("should write the CDI spec while healthy and remove it while unhealthy", func() {
+		Expect(dp.syncCDISpec(pluginapi.Healthy)).Should(Succeed())
+		spec, err := readSpec()
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(spec).Should(Equal(&cdi.Spec{
+			Version: cdi.Version,
+			Kind:    "test.org/test-resource",
+			Devices: []cdi.Device{{
+				Name: "hostpath",
+				ContainerEdits: cdi.ContainerEdits{
+					Env:         []string{"A=test.org/test.resource", "B=/data"},
+					DeviceNodes: []*cdi.DeviceNode{{Path: "/dev/fuse", HostPath: "/dev/fuse", Permissions: "rw"}},
+					Mounts: []*cdi.Mount{{
+						HostPath:      hostPath,
+						ContainerPath: "/data",
+						Type:          "bind",
+						Options:       []string{"rbind", "ro"},
+					}},
+				},
+			}},
+		}))
+
+		Expect(dp.syncCDISpec(pluginapi.Unhealthy)).Should(Succeed())
+		_, err = readSpec()
+		Expect(os.IsNotExist(err)).Should(BeTrue())
+	})
+
+	It("should return the CDI device in Allocate", func() {
+		res, err := dp.Allocate(context.Background(), &pluginapi.AllocateRequest{
+			ContainerRequests: []*pluginapi.ContainerAllocateRequest{
+				{DevicesIDs: []string{"0"}},
+				{DevicesIDs: []string{"1"}},
+			},
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(res.ContainerResponses).Should(HaveLen(2))
+		for i, r := range res.ContainerResponses {
+			Expect(r.CDIDevices).Should(Equal([]*pluginapi.CDIDevice{{Name: "test.org/test-resource=hostpath"}}))
+			// the CDI spec declares them
+			Expect(r.Mounts).Should(BeEmpty())
+			Expect(r.Devices).Should(BeEmpty())
+			Expect(r.Envs).Should(BeEmpty())
+			Expect(r.Annotations).Should(Equal(map[string]string{"test.org/device-ids": []string{"0", "1"}[i]}))
+		}
+	})
+})
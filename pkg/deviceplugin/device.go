@@ -0,0 +1,177 @@
+package deviceplugin
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/pkg/errors"
+	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
+)
+
+// hostPathDevice is a device backed by a host path
+type hostPathDevice struct {
+	pluginapi.Device
+	// path is the host path backing the device
+	path string
+}
+
+// backingPaths returns host paths backing devices.  These are paths matching DevicePaths
+// if specified.  Otherwise, all the devices are backed by HostPath.
+func (m *HostPathDevicePlugin) backingPaths() ([]string, error) {
+	if m.config.DevicePaths == "" {
+		return []string{m.config.HostPath.Path}, nil
+	}
+	paths, err := filepath.Glob(m.config.DevicePaths)
+	if err != nil {
+		return nil, err
+	}
+	return paths, nil
+}
+
+// newDevices returns NumDevices devices with the topology for each path
+func (m *HostPathDevicePlugin) newDevices(paths []string, healths map[string]string) []*hostPathDevice {
+	devs := make([]*hostPathDevice, 0, len(paths)*m.config.NumDevices)
+	for _, path := range paths {
+		topology := m.topology(path)
+		for i := 0; i < m.config.NumDevices; i++ {
+			devs = append(devs, &hostPathDevice{
+				Device: pluginapi.Device{
+					ID:       m.deviceID(path, i, len(devs)),
+					Health:   healths[path],
+					Topology: topology,
+				},
+				path: path,
+			})
+		}
+	}
+	return devs
+}
+
+// deviceID returns the ID of i-th device backed by path.  When DevicePaths is specified, the ID is
+// derived from path(e.g. "ssd0-1a2b3c4d") so that it is stable while paths are added or removed.
+// Otherwise, it is the sequence number seq.
+func (m *HostPathDevicePlugin) deviceID(path string, i, seq int) string {
+	if m.config.DevicePaths == "" {
+		return fmt.Sprint(seq)
+	}
+	sum := sha256.Sum256([]byte(path))
+	id := fmt.Sprintf("%s-%x", filepath.Base(path), sum[:4])
+	if m.config.NumDevices > 1 {
+		id = fmt.Sprintf("%s-%d", id, i)
+	}
+	return id
+}
+
+// discover re-scans paths matching DevicePaths and updates devices if they changed.  Health of
+// devices backed by existing paths are kept.  It returns true when devices are updated.
+func (m *HostPathDevicePlugin) discover() bool {
+	if m.config.DevicePaths == "" {
+		return false
+	}
+
+	paths, err := m.backingPaths()
+	if err != nil {
+		m.logger.Error().Err(err).Str("DevicePaths", m.config.DevicePaths).Msg("Failed to discover device paths")
+		return false
+	}
+	current := m.paths()
+	if slices.Equal(paths, current) {
+		return false
+	}
+
+	healths := map[string]string{}
+	m.mu.Lock()
+	for _, dev := range m.devs {
+		healths[dev.path] = dev.Health
+	}
+	m.mu.Unlock()
+	for _, path := range paths {
+		if _, ok := healths[path]; !ok {
+			healths[path] = m.getHealth(path)
+		}
+	}
+	devs := m.newDevices(paths, healths)
+
+	m.logger.Info().
+		Strs("LastPaths", current).
+		Strs("Paths", paths).
+		Int("Devices", len(devs)).Msg("Device paths are changed")
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.devs = devs
+	m.notifyWatchers()
+	return true
+}
+
+// isDevicePathCandidate returns true when path matches DevicePaths
+func (m *HostPathDevicePlugin) isDevicePathCandidate(path string) bool {
+	if m.config.DevicePaths == "" {
+		return false
+	}
+	matched, _ := filepath.Match(m.config.DevicePaths, path)
+	return matched
+}
+
+// globBaseDir returns the longest directory of pattern which doesn't contain glob meta characters
+func globBaseDir(pattern string) string {
+	dir := filepath.Dir(filepath.Clean(pattern))
+	for strings.ContainsAny(dir, `*?[\`) {
+		dir = filepath.Dir(dir)
+	}
+	return dir
+}
+
+// device returns a copy of the device with id
+func (m *HostPathDevicePlugin) device(id string) (hostPathDevice, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, dev := range m.devs {
+		if dev.ID == id {
+			return *dev, true
+		}
+	}
+	return hostPathDevice{}, false
+}
+
+// deviceDir returns the host directory exclusively backing dev in exclusive mode.  This is the
+// path matched by DevicePaths when it backs a single device, otherwise the subdirectory named by
+// the device ID.
+func (m *HostPathDevicePlugin) deviceDir(dev hostPathDevice) string {
+	if m.config.DevicePaths != "" && m.config.NumDevices == 1 {
+		return dev.path
+	}
+	return filepath.Join(dev.path, dev.ID)
+}
+
+// exclusiveMounts returns mounts of the directories backing deviceIDs.  A single device is mounted
+// at MountPath.  Multiple devices are mounted at MountPath/<device ID>.
+func (m *HostPathDevicePlugin) exclusiveMounts(deviceIDs []string) ([]*pluginapi.Mount, error) {
+	mounts := make([]*pluginapi.Mount, 0, len(deviceIDs))
+	for _, id := range deviceIDs {
+		dev, ok := m.device(id)
+		if !ok {
+			return nil, errors.Errorf("unknown device %s", id)
+		}
+		dir := m.deviceDir(dev)
+		if dir != dev.path {
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				return nil, errors.Wrapf(err, "failed to create directory of device %s", id)
+			}
+		}
+		containerPath := m.config.VolumeMount.MountPath
+		if len(deviceIDs) > 1 {
+			containerPath = filepath.Join(containerPath, id)
+		}
+		mounts = append(mounts, &pluginapi.Mount{
+			ContainerPath: containerPath,
+			HostPath:      dir,
+			ReadOnly:      m.config.VolumeMount.ReadOnly,
+		})
+	}
+	return mounts, nil
+}
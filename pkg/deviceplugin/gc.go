@@ -0,0 +1,142 @@
+package deviceplugin
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	podresourcesapi "k8s.io/kubelet/pkg/apis/podresources/v1"
+)
+
+// garbageCollect periodically removes directories backing devices which are no longer allocated
+func (m *HostPathDevicePlugin) garbageCollect() {
+	gc := m.config.GarbageCollection
+	m.logger.Info().
+		Dur("Interval", gc.Interval).
+		Dur("GracePeriod", gc.GracePeriod).
+		Bool("DryRun", gc.DryRun).Msg("Starting garbage collection")
+	ticker := time.NewTicker(gc.Interval)
+	defer ticker.Stop()
+
+	deallocatedAt := map[string]time.Time{}
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+			m.collectGarbage(deallocatedAt, time.Now())
+		}
+	}
+}
+
+// collectGarbage removes subdirectories backing devices which have not been allocated for GracePeriod.
+// deallocatedAt holds when each device was found not allocated.  Paths matched by DevicePaths are never
+// removed.  Nothing is removed when allocated devices can't be looked up.
+func (m *HostPathDevicePlugin) collectGarbage(deallocatedAt map[string]time.Time, now time.Time) {
+	gc := m.config.GarbageCollection
+	allocated, err := m.allocatedDeviceIDs()
+	if err != nil {
+		m.logger.Error().Err(err).Str("PodResourcesSocket", gc.PodResourcesSocket).Msg("Failed to list allocated devices.  Skipping garbage collection")
+		return
+	}
+
+	m.mu.Lock()
+	devs := make([]hostPathDevice, 0, len(m.devs))
+	for _, dev := range m.devs {
+		devs = append(devs, *dev)
+	}
+	allocatedAt := make(map[string]time.Time, len(m.allocatedAt))
+	for id, t := range m.allocatedAt {
+		allocatedAt[id] = t
+	}
+	m.mu.Unlock()
+
+	known := map[string]bool{}
+	for _, dev := range devs {
+		known[dev.ID] = true
+		dir := m.deviceDir(dev)
+		// Allocate may be called before PodResources API reports the allocation
+		if dir == dev.path || allocated[dev.ID] || now.Sub(allocatedAt[dev.ID]) < gc.GracePeriod {
+			delete(deallocatedAt, dev.ID)
+			continue
+		}
+		if _, err := os.Lstat(dir); os.IsNotExist(err) {
+			delete(deallocatedAt, dev.ID)
+			continue
+		}
+		since, ok := deallocatedAt[dev.ID]
+		if !ok {
+			deallocatedAt[dev.ID] = now
+			continue
+		}
+		if now.Sub(since) < gc.GracePeriod {
+			continue
+		}
+
+		logger := m.logger.With().Str("DeviceID", dev.ID).Str("Dir", dir).Logger()
+		if gc.DryRun {
+			logger.Info().Msg("Dry run: would remove the directory of the deallocated device")
+			continue
+		}
+		removed, err := m.removeDeviceDir(dev.ID, dir, allocatedAt[dev.ID])
+		if err != nil {
+			logger.Error().Err(err).Msg("Failed to remove the directory of the deallocated device")
+			continue
+		}
+		if !removed {
+			logger.Debug().Msg("The device was allocated during garbage collection.  Skipping removal")
+			delete(deallocatedAt, dev.ID)
+			continue
+		}
+		logger.Info().Msg("Removed the directory of the deallocated device")
+		delete(deallocatedAt, dev.ID)
+	}
+	for id := range deallocatedAt {
+		if !known[id] {
+			delete(deallocatedAt, id)
+		}
+	}
+}
+
+// removeDeviceDir removes dir of the device unless the device has been allocated after allocatedAt.  m.mu is
+// held while removing because Allocate records the allocation under m.mu before creating the directory.
+func (m *HostPathDevicePlugin) removeDeviceDir(id, dir string, allocatedAt time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.allocatedAt[id].After(allocatedAt) {
+		return false, nil
+	}
+	return true, os.RemoveAll(dir)
+}
+
+// allocatedDeviceIDs returns IDs of devices of the resource allocated to containers by kubelet PodResources API
+func (m *HostPathDevicePlugin) allocatedDeviceIDs() (map[string]bool, error) {
+	conn, err := dial(m.config.GarbageCollection.PodResourcesSocket, 5*time.Second)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to connect to PodResources API")
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	resp, err := podresourcesapi.NewPodResourcesListerClient(conn).List(ctx, &podresourcesapi.ListPodResourcesRequest{})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list pod resources")
+	}
+
+	allocated := map[string]bool{}
+	for _, pod := range resp.GetPodResources() {
+		for _, container := range pod.GetContainers() {
+			for _, dev := range container.GetDevices() {
+				if dev.GetResourceName() != m.config.ResourceName {
+					continue
+				}
+				for _, id := range dev.GetDeviceIds() {
+					allocated[id] = true
+				}
+			}
+		}
+	}
+	return allocated, nil
+}
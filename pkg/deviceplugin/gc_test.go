@@ -0,0 +1,151 @@
+package deviceplugin
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/everpeace/k8s-hostpath-device-plugin/pkg/config"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc"
+	corev1 "k8s.io/api/core/v1"
+	podresourcesapi "k8s.io/kubelet/pkg/apis/podresources/v1"
+)
+
+type fakePodResourcesServer struct {
+	podresourcesapi.UnimplementedPodResourcesListerServer
+	resp *podresourcesapi.ListPodResourcesResponse
+}
+
+func (s *fakePodResourcesServer) List(context.Context, *podresourcesapi.ListPodResourcesRequest) (*podresourcesapi.ListPodResourcesResponse, error) {
+	return s.resp, nil
+}
+
+var _ = Describe("Garbage collection", func() {
+	var hostPath string
+	var server *grpc.Server
+	var podResources *fakePodResourcesServer
+	BeforeEach(func() {
+		var err error
+		hostPath, err = os.MkdirTemp("", "hostpath")
+		Expect(err).ShouldNot(HaveOccurred())
+
+		podResources = &fakePodResourcesServer{resp: &podresourcesapi.ListPodResourcesResponse{}}
+		socket, err := net.Listen("unix", filepath.Join(hostPath, "kubelet.sock"))
+		Expect(err).ShouldNot(HaveOccurred())
+		server = grpc.NewServer()
+		podresourcesapi.RegisterPodResourcesListerServer(server, podResources)
+		go func() {
+			_ = server.Serve(socket)
+		}()
+	})
+	AfterEach(func() {
+		server.Stop()
+		Expect(os.RemoveAll(hostPath)).Should(Succeed())
+	})
+
+	newDevicePlugin := func(dryRun bool) *HostPathDevicePlugin {
+		dp, err := NewHostPathDevicePlugin(config.HostPathDevicePluginConfig{
+			ResourceName: "test.org/test-resource",
+			HostPath:     corev1.HostPathVolumeSource{Path: filepath.Join(hostPath, "devices")},
+			NumDevices:   3,
+			Injection:    config.InjectionAllocate,
+			Exclusive:    true,
+			GarbageCollection: &config.GarbageCollection{
+				Interval:           time.Minute,
+				GracePeriod:        time.Minute,
+				DryRun:             dryRun,
+				PodResourcesSocket: filepath.Join(hostPath, "kubelet.sock"),
+			},
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+		return dp
+	}
+	allocate := func(deviceIDs ...string) {
+		podResources.resp = &podresourcesapi.ListPodResourcesResponse{
+			PodResources: []*podresourcesapi.PodResources{{
+				Name:      "pod",
+				Namespace: "default",
+				Containers: []*podresourcesapi.ContainerResources{{
+					Name: "ctr",
+					Devices: []*podresourcesapi.ContainerDevices{
+						{ResourceName: "test.org/test-resource", DeviceIds: deviceIDs},
+						{ResourceName: "test.org/other-resource", DeviceIds: []string{"2"}},
+					},
+				}},
+			}},
+		}
+	}
+	dir := func(id string) string {
+		return filepath.Join(hostPath, "devices", id)
+	}
+
+	It("should list allocated devices of the resource", func() {
+		dp := newDevicePlugin(false)
+		allocate("0", "1")
+		Expect(dp.allocatedDeviceIDs()).Should(Equal(map[string]bool{"0": true, "1": true}))
+	})
+
+	It("should remove directories of devices deallocated for the grace period", func() {
+		dp := newDevicePlugin(false)
+		for _, id := range []string{"0", "1", "2"} {
+			Expect(os.MkdirAll(dir(id), 0755)).Should(Succeed())
+		}
+		allocate("0")
+		now := time.Now()
+		dp.allocatedAt["1"] = now.Add(-30 * time.Second)
+
+		deallocatedAt := map[string]time.Time{}
+		dp.collectGarbage(deallocatedAt, now)
+		Expect(deallocatedAt).Should(Equal(map[string]time.Time{"2": now}))
+		for _, id := range []string{"0", "1", "2"} {
+			Expect(dir(id)).Should(BeADirectory())
+		}
+
+		allocate()
+		dp.collectGarbage(deallocatedAt, now.Add(time.Minute))
+		Expect(dir("0")).Should(BeADirectory())
+		Expect(dir("1")).Should(BeADirectory())
+		Expect(dir("2")).ShouldNot(BeAnExistingFile())
+
+		dp.collectGarbage(deallocatedAt, now.Add(3*time.Minute))
+		Expect(dir("0")).ShouldNot(BeAnExistingFile())
+		Expect(dir("1")).ShouldNot(BeAnExistingFile())
+		Expect(deallocatedAt).Should(BeEmpty())
+	})
+
+	It("should not remove directories of devices allocated during garbage collection", func() {
+		dp := newDevicePlugin(false)
+		Expect(os.MkdirAll(dir("0"), 0755)).Should(Succeed())
+		snapshot := dp.allocatedAt["0"]
+		dp.recordAllocation([]string{"0"})
+		Expect(dp.removeDeviceDir("0", dir("0"), snapshot)).Should(BeFalse())
+		Expect(dir("0")).Should(BeADirectory())
+
+		Expect(dp.removeDeviceDir("0", dir("0"), dp.allocatedAt["0"])).Should(BeTrue())
+		Expect(dir("0")).ShouldNot(BeAnExistingFile())
+	})
+
+	It("should not remove directories in dry run", func() {
+		dp := newDevicePlugin(true)
+		Expect(os.MkdirAll(dir("0"), 0755)).Should(Succeed())
+		now := time.Now()
+		deallocatedAt := map[string]time.Time{}
+		dp.collectGarbage(deallocatedAt, now)
+		dp.collectGarbage(deallocatedAt, now.Add(time.Minute))
+		Expect(dir("0")).Should(BeADirectory())
+	})
+
+	It("should not remove directories when PodResources API is unavailable", func() {
+		dp := newDevicePlugin(false)
+		Expect(os.MkdirAll(dir("0"), 0755)).Should(Succeed())
+		server.Stop()
+		now := time.Now()
+		deallocatedAt := map[string]time.Time{"0": now.Add(-time.Hour)}
+		dp.collectGarbage(deallocatedAt, now)
+		Expect(dir("0")).Should(BeADirectory())
+	})
+})
@@ -0,0 +1,62 @@
+package deviceplugin
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	statusRegistered    = "registered"
+	statusNotRegistered = "not registered"
+)
+
+// registrationStatus returns "registered" or "not registered" of each resource, and whether all of them are registered
+func (r *Runner) registrationStatus() (map[string]string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	status := map[string]string{}
+	ready := true
+	for _, cfg := range r.cfg.Resources {
+		if r.registered[cfg.ResourceName] {
+			status[cfg.ResourceName] = statusRegistered
+		} else {
+			status[cfg.ResourceName] = statusNotRegistered
+			ready = false
+		}
+	}
+	return status, ready
+}
+
+// healthHandler returns the handler of health endpoints.  /healthz always succeeds while the process
+// is running.  /readyz succeeds only when all the resources are registered to kubelet.  Both respond
+// the registration status of each resource.
+func (r *Runner) healthHandler() http.Handler {
+	respond := func(w http.ResponseWriter, requireReady bool) {
+		status, ready := r.registrationStatus()
+		w.Header().Set("Content-Type", "application/json")
+		if requireReady && !ready {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		} else {
+			w.WriteHeader(http.StatusOK)
+		}
+		_ = json.NewEncoder(w).Encode(status)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+		respond(w, false)
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, _ *http.Request) {
+		respond(w, true)
+	})
+	return mux
+}
+
+func (r *Runner) serveHealth() {
+	log.Info().Str("Listen", r.runnerCfg.HealthListen).Msg("Start serving health endpoints")
+	if err := http.ListenAndServe(r.runnerCfg.HealthListen, r.healthHandler()); err != nil {
+		log.Error().Err(err).Str("Listen", r.runnerCfg.HealthListen).Msg("Failed to serve health endpoints")
+	}
+}
@@ -0,0 +1,70 @@
+package deviceplugin
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// checkHostPathType checks path satisfies pathType in the same manner as kubelet does when mounting
+// hostPath volume.  Like kubelet, DirectoryOrCreate and FileOrCreate create path when it doesn't exist.
+// Unlike kubelet, path must exist even when pathType is unset because the device plugin serves it.
+func checkHostPathType(path string, pathType *corev1.HostPathType) error {
+	t := corev1.HostPathUnset
+	if pathType != nil {
+		t = *pathType
+	}
+
+	fi, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		switch t {
+		case corev1.HostPathDirectoryOrCreate:
+			if err := os.MkdirAll(path, 0755); err != nil {
+				return errors.Wrapf(err, "failed to create directory %s", path)
+			}
+			return nil
+		case corev1.HostPathFileOrCreate:
+			// the parent directory is not created as kubelet does
+			f, err := os.OpenFile(path, os.O_CREATE, 0644)
+			if err != nil {
+				return errors.Wrapf(err, "failed to create file %s", path)
+			}
+			return f.Close()
+		default:
+			return errors.Errorf("%s not found", path)
+		}
+	}
+	if err != nil {
+		return errors.Wrapf(err, "failed to stat %s", path)
+	}
+
+	mode := fi.Mode()
+	switch t {
+	case corev1.HostPathUnset:
+		return nil
+	case corev1.HostPathDirectoryOrCreate, corev1.HostPathDirectory:
+		if !mode.IsDir() {
+			return errors.Errorf("%s is not a directory(mode=%s) but hostPath.type is %s", path, mode, t)
+		}
+	case corev1.HostPathFileOrCreate, corev1.HostPathFile:
+		if !mode.IsRegular() {
+			return errors.Errorf("%s is not a file(mode=%s) but hostPath.type is %s", path, mode, t)
+		}
+	case corev1.HostPathSocket:
+		if mode&os.ModeSocket == 0 {
+			return errors.Errorf("%s is not a socket(mode=%s) but hostPath.type is %s", path, mode, t)
+		}
+	case corev1.HostPathCharDev:
+		if mode&os.ModeCharDevice == 0 {
+			return errors.Errorf("%s is not a character device(mode=%s) but hostPath.type is %s", path, mode, t)
+		}
+	case corev1.HostPathBlockDev:
+		if mode&os.ModeDevice == 0 || mode&os.ModeCharDevice != 0 {
+			return errors.Errorf("%s is not a block device(mode=%s) but hostPath.type is %s", path, mode, t)
+		}
+	default:
+		return errors.Errorf("unsupported hostPath.type %s", t)
+	}
+	return nil
+}
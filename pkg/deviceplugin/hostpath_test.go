@@ -0,0 +1,78 @@
+package deviceplugin
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+)
+
+var _ = Describe("checkHostPathType", func() {
+	var dir, file, socket string
+	var listener net.Listener
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "hostpath")
+		Expect(err).ShouldNot(HaveOccurred())
+		file = filepath.Join(dir, "file")
+		Expect(os.WriteFile(file, nil, 0644)).Should(Succeed())
+		socket = filepath.Join(dir, "socket")
+		listener, err = net.Listen("unix", socket)
+		Expect(err).ShouldNot(HaveOccurred())
+	})
+	AfterEach(func() {
+		Expect(listener.Close()).Should(Succeed())
+		Expect(os.RemoveAll(dir)).Should(Succeed())
+	})
+
+	type testCase struct {
+		name     string
+		pathType corev1.HostPathType
+		// path returns the path to check
+		path func() string
+		// created is true when the path is expected to be created
+		created bool
+		valid   bool
+	}
+	for _, c := range []testCase{
+		{name: "unset with a directory", pathType: corev1.HostPathUnset, path: func() string { return dir }, valid: true},
+		{name: "unset with a missing path", pathType: corev1.HostPathUnset, path: func() string { return filepath.Join(dir, "missing") }, valid: false},
+		{name: "Directory with a directory", pathType: corev1.HostPathDirectory, path: func() string { return dir }, valid: true},
+		{name: "Directory with a regular file", pathType: corev1.HostPathDirectory, path: func() string { return file }, valid: false},
+		{name: "Directory with a missing path", pathType: corev1.HostPathDirectory, path: func() string { return filepath.Join(dir, "missing") }, valid: false},
+		{name: "DirectoryOrCreate with a missing path", pathType: corev1.HostPathDirectoryOrCreate, path: func() string { return filepath.Join(dir, "a", "b") }, created: true, valid: true},
+		{name: "DirectoryOrCreate with a regular file", pathType: corev1.HostPathDirectoryOrCreate, path: func() string { return file }, valid: false},
+		{name: "File with a regular file", pathType: corev1.HostPathFile, path: func() string { return file }, valid: true},
+		{name: "File with a directory", pathType: corev1.HostPathFile, path: func() string { return dir }, valid: false},
+		{name: "FileOrCreate with a missing path", pathType: corev1.HostPathFileOrCreate, path: func() string { return filepath.Join(dir, "new") }, created: true, valid: true},
+		{name: "FileOrCreate with a missing parent", pathType: corev1.HostPathFileOrCreate, path: func() string { return filepath.Join(dir, "missing", "new") }, valid: false},
+		{name: "Socket with a socket", pathType: corev1.HostPathSocket, path: func() string { return socket }, valid: true},
+		{name: "Socket with a regular file", pathType: corev1.HostPathSocket, path: func() string { return file }, valid: false},
+		{name: "CharDevice with /dev/null", pathType: corev1.HostPathCharDev, path: func() string { return "/dev/null" }, valid: true},
+		{name: "CharDevice with a regular file", pathType: corev1.HostPathCharDev, path: func() string { return file }, valid: false},
+		{name: "BlockDevice with /dev/null", pathType: corev1.HostPathBlockDev, path: func() string { return "/dev/null" }, valid: false},
+		{name: "unsupported type", pathType: corev1.HostPathType("Unknown"), path: func() string { return dir }, valid: false},
+	} {
+		c := c
+		It("should check "+c.name, func() {
+			path := c.path()
+			err := checkHostPathType(path, &c.pathType)
+			if c.valid {
+				Expect(err).ShouldNot(HaveOccurred())
+			} else {
+				Expect(err).Should(HaveOccurred())
+			}
+			if c.created {
+				Expect(path).Should(BeAnExistingFile())
+			}
+		})
+	}
+
+	It("should treat nil type as unset", func() {
+		Expect(checkHostPathType(dir, nil)).Should(Succeed())
+		Expect(checkHostPathType(filepath.Join(dir, "missing"), nil)).ShouldNot(Succeed())
+	})
+})
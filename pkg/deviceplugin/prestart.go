@@ -0,0 +1,63 @@
+package deviceplugin
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/everpeace/k8s-hostpath-device-plugin/pkg/config"
+	"github.com/pkg/errors"
+)
+
+// preStartDirs returns directories to be prepared for the container allocated deviceIDs.
+// PreStartContainerRequest doesn't tell which pod the container belongs to.  So, directories are
+// prepared per device, which are exclusively owned by the pod in Exclusive mode.
+func (m *HostPathDevicePlugin) preStartDirs(deviceIDs []string) ([]string, error) {
+	if !m.config.Exclusive {
+		return []string{m.config.HostPath.Path}, nil
+	}
+	dirs := make([]string, 0, len(deviceIDs))
+	for _, id := range deviceIDs {
+		dev, ok := m.device(id)
+		if !ok {
+			return nil, errors.Errorf("unknown device %s", id)
+		}
+		dirs = append(dirs, m.deviceDir(dev))
+	}
+	return dirs, nil
+}
+
+// prepareDir creates dir if not exists, wipes its contents, and then applies the owner and permissions
+func prepareDir(dir string, preStart config.PreStart) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return errors.Wrapf(err, "failed to create %s", dir)
+	}
+	if preStart.Wipe {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return errors.Wrapf(err, "failed to read %s", dir)
+		}
+		for _, entry := range entries {
+			if err := os.RemoveAll(filepath.Join(dir, entry.Name())); err != nil {
+				return errors.Wrapf(err, "failed to wipe %s", dir)
+			}
+		}
+	}
+	if preStart.UID != nil || preStart.GID != nil {
+		uid, gid := -1, -1
+		if preStart.UID != nil {
+			uid = int(*preStart.UID)
+		}
+		if preStart.GID != nil {
+			gid = int(*preStart.GID)
+		}
+		if err := os.Chown(dir, uid, gid); err != nil {
+			return errors.Wrapf(err, "failed to chown %s", dir)
+		}
+	}
+	if preStart.Mode != "" {
+		if err := os.Chmod(dir, preStart.FileMode()); err != nil {
+			return errors.Wrapf(err, "failed to chmod %s", dir)
+		}
+	}
+	return nil
+}
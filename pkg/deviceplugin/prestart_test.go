@@ -0,0 +1,85 @@
+package deviceplugin
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"github.com/everpeace/k8s-hostpath-device-plugin/pkg/config"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
+)
+
+var _ = Describe("PreStartContainer", func() {
+	var hostPath string
+	BeforeEach(func() {
+		var err error
+		hostPath, err = os.MkdirTemp("", "hostpath")
+		Expect(err).ShouldNot(HaveOccurred())
+	})
+	AfterEach(func() {
+		Expect(os.RemoveAll(hostPath)).Should(Succeed())
+	})
+
+	newDevicePlugin := func(exclusive bool, preStart *config.PreStart) *HostPathDevicePlugin {
+		dp, err := NewHostPathDevicePlugin(config.HostPathDevicePluginConfig{
+			ResourceName: "test.org/test-resource",
+			HostPath:     corev1.HostPathVolumeSource{Path: hostPath},
+			VolumeMount:  corev1.VolumeMount{MountPath: "/data"},
+			NumDevices:   2,
+			Injection:    config.InjectionAllocate,
+			Exclusive:    exclusive,
+			PreStart:     preStart,
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+		return dp
+	}
+	preStart := func(dp *HostPathDevicePlugin, deviceIDs ...string) error {
+		_, err := dp.PreStartContainer(context.Background(), &pluginapi.PreStartContainerRequest{DevicesIDs: deviceIDs})
+		return err
+	}
+
+	It("should require PreStartContainer only when preStart is set", func() {
+		opts, err := newDevicePlugin(false, nil).GetDevicePluginOptions(context.Background(), &pluginapi.Empty{})
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(opts.PreStartRequired).Should(BeFalse())
+
+		opts, err = newDevicePlugin(false, &config.PreStart{}).GetDevicePluginOptions(context.Background(), &pluginapi.Empty{})
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(opts.PreStartRequired).Should(BeTrue())
+	})
+
+	It("should prepare directories of the allocated devices in exclusive mode", func() {
+		uid, gid := int64(os.Getuid()), int64(os.Getgid())
+		dp := newDevicePlugin(true, &config.PreStart{UID: &uid, GID: &gid, Mode: "0750", Wipe: true})
+		dir := filepath.Join(hostPath, "1")
+		Expect(os.MkdirAll(filepath.Join(dir, "stale"), 0755)).Should(Succeed())
+		Expect(os.WriteFile(filepath.Join(dir, "stale", "file"), []byte("stale"), 0644)).Should(Succeed())
+
+		Expect(preStart(dp, "0", "1")).Should(Succeed())
+		for _, d := range []string{filepath.Join(hostPath, "0"), dir} {
+			info, err := os.Stat(d)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(info.Mode()).Should(Equal(os.ModeDir | 0750))
+			Expect(os.ReadDir(d)).Should(BeEmpty())
+		}
+
+		Expect(preStart(dp, "2")).ShouldNot(Succeed())
+	})
+
+	It("should only create the host path", func() {
+		dp := newDevicePlugin(false, &config.PreStart{})
+		Expect(os.RemoveAll(hostPath)).Should(Succeed())
+
+		Expect(preStart(dp, "0")).Should(Succeed())
+		Expect(hostPath).Should(BeADirectory())
+	})
+
+	It("should surface failures", func() {
+		Expect(os.WriteFile(filepath.Join(hostPath, "0"), []byte("not a directory"), 0644)).Should(Succeed())
+		dp := newDevicePlugin(true, &config.PreStart{})
+		Expect(preStart(dp, "0")).ShouldNot(Succeed())
+	})
+})
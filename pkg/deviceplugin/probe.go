@@ -0,0 +1,163 @@
+package deviceplugin
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/everpeace/k8s-hostpath-device-plugin/pkg/config"
+	"github.com/pkg/errors"
+)
+
+var (
+	// mountInfoPath is the mountinfo of the device plugin's mount namespace.  This is a variable for tests.
+	mountInfoPath = "/proc/self/mountinfo"
+)
+
+// runProbe runs the probe against path.  It returns an error describing why the probe failed.
+func runProbe(probe config.Probe, path string) error {
+	switch probe.Type {
+	case config.ProbeMountPoint:
+		resolved, err := filepath.EvalSymlinks(path)
+		if err != nil {
+			return err
+		}
+		mount, err := findMount(resolved)
+		if err != nil {
+			return err
+		}
+		if mount.mountPoint != resolved {
+			return errors.Errorf("%s is not a mount point(mounted under %s)", path, mount.mountPoint)
+		}
+	case config.ProbeFSType:
+		mount, err := findMount(path)
+		if err != nil {
+			return err
+		}
+		if !slices.Contains(probe.FSTypes, mount.fsType) {
+			return errors.Errorf("filesystem type of %s is %s, expected one of %v", path, mount.fsType, probe.FSTypes)
+		}
+	case config.ProbeWritable:
+		f, err := os.CreateTemp(path, ".k8s-hostpath-device-plugin-probe-*")
+		if err != nil {
+			return errors.Wrapf(err, "%s is not writable", path)
+		}
+		f.Close()
+		if err := os.Remove(f.Name()); err != nil {
+			return errors.Wrapf(err, "failed to remove probe file in %s", path)
+		}
+	case config.ProbeFreeSpace:
+		var st syscall.Statfs_t
+		if err := syscall.Statfs(path, &st); err != nil {
+			return errors.Wrapf(err, "failed to statfs %s", path)
+		}
+		freeBytes := uint64(st.Bavail) * uint64(st.Bsize)
+		if freeBytes < probe.MinFreeBytes {
+			return errors.Errorf("available bytes of %s is %d, expected at least %d", path, freeBytes, probe.MinFreeBytes)
+		}
+		if uint64(st.Ffree) < probe.MinFreeInodes {
+			return errors.Errorf("free inodes of %s is %d, expected at least %d", path, uint64(st.Ffree), probe.MinFreeInodes)
+		}
+	case config.ProbeFileExists:
+		file := filepath.Join(path, probe.File)
+		if _, err := os.Stat(file); err != nil {
+			return errors.Wrapf(err, "failed to stat %s", file)
+		}
+	case config.ProbeExec:
+		ctx, cancel := context.WithTimeout(context.Background(), probe.Timeout)
+		defer cancel()
+		cmd := exec.CommandContext(ctx, probe.Command[0], probe.Command[1:]...)
+		cmd.Env = append(os.Environ(), "HOST_PATH="+path)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			if ctx.Err() == context.DeadlineExceeded {
+				return errors.Errorf("command %v timed out after %s", probe.Command, probe.Timeout)
+			}
+			return errors.Wrapf(err, "command %v failed: %s", probe.Command, strings.TrimSpace(string(out)))
+		}
+	default:
+		return errors.Errorf("unknown probe type %s", probe.Type)
+	}
+	return nil
+}
+
+type mountInfo struct {
+	mountPoint string
+	fsType     string
+}
+
+// findMount returns the mount which path belongs to by looking up mountinfo
+// of the device plugin's mount namespace.
+func findMount(path string) (mountInfo, error) {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return mountInfo{}, err
+	}
+
+	f, err := os.Open(mountInfoPath)
+	if err != nil {
+		return mountInfo{}, err
+	}
+	defer f.Close()
+
+	found := mountInfo{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		mount, ok := parseMountInfoLine(scanner.Text())
+		if !ok {
+			continue
+		}
+		// the last matched mount wins because later mounts shadow earlier ones
+		if isUnder(resolved, mount.mountPoint) && len(mount.mountPoint) >= len(found.mountPoint) {
+			found = mount
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return mountInfo{}, err
+	}
+	if found.mountPoint == "" {
+		return mountInfo{}, errors.Errorf("mount of %s not found in %s", path, mountInfoPath)
+	}
+	return found, nil
+}
+
+// parseMountInfoLine parses a line of mountinfo. See proc(5) for the format.
+func parseMountInfoLine(line string) (mountInfo, bool) {
+	fields := strings.Fields(line)
+	sep := slices.Index(fields, "-")
+	if sep < 5 || len(fields) < sep+2 {
+		return mountInfo{}, false
+	}
+	return mountInfo{
+		mountPoint: unescapeMountInfo(fields[4]),
+		fsType:     fields[sep+1],
+	}, true
+}
+
+// unescapeMountInfo unescapes octal escapes(e.g. "\040" for space) in mountinfo
+func unescapeMountInfo(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+4 <= len(s) {
+			if c, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+func isUnder(path, dir string) bool {
+	return path == dir || dir == "/" || strings.HasPrefix(path, dir+"/")
+}
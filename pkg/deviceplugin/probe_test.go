@@ -0,0 +1,145 @@
+package deviceplugin
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/everpeace/k8s-hostpath-device-plugin/pkg/config"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("mountinfo", func() {
+	It("should parse mountinfo lines", func() {
+		parse := func(line string) mountInfo {
+			mount, ok := parseMountInfoLine(line)
+			Expect(ok).Should(BeTrue(), line)
+			return mount
+		}
+		Expect(parse("36 35 98:0 /mnt1 /mnt/parent rw,noatime master:1 - ext3 /dev/root rw,errors=continue")).
+			Should(Equal(mountInfo{mountPoint: "/mnt/parent", fsType: "ext3"}))
+		// no optional fields
+		Expect(parse("25 1 0:22 / /dev/shm rw - tmpfs tmpfs rw")).
+			Should(Equal(mountInfo{mountPoint: "/dev/shm", fsType: "tmpfs"}))
+		// multiple optional fields and escaped mount point
+		Expect(parse(`40 25 0:35 / /mnt/with\040space rw shared:2 master:3 - xfs /dev/sdb1 rw`)).
+			Should(Equal(mountInfo{mountPoint: "/mnt/with space", fsType: "xfs"}))
+
+		for _, line := range []string{"", "36 35 98:0 /mnt1 /mnt/parent rw", "36 35 98:0 - ext3"} {
+			_, ok := parseMountInfoLine(line)
+			Expect(ok).Should(BeFalse(), line)
+		}
+	})
+
+	It("should unescape octal escapes", func() {
+		Expect(unescapeMountInfo(`/mnt/a`)).Should(Equal("/mnt/a"))
+		Expect(unescapeMountInfo(`/mnt/a\040b\011c\012d`)).Should(Equal("/mnt/a b\tc\nd"))
+		Expect(unescapeMountInfo(`/mnt/back\134slash`)).Should(Equal(`/mnt/back\slash`))
+		// not escapes
+		Expect(unescapeMountInfo(`/mnt/a\9b`)).Should(Equal(`/mnt/a\9b`))
+		Expect(unescapeMountInfo(`/mnt/a\04`)).Should(Equal(`/mnt/a\04`))
+	})
+
+	It("should check path is under dir", func() {
+		Expect(isUnder("/mnt/a", "/")).Should(BeTrue())
+		Expect(isUnder("/mnt/a", "/mnt/a")).Should(BeTrue())
+		Expect(isUnder("/mnt/a/b", "/mnt/a")).Should(BeTrue())
+		Expect(isUnder("/mnt/ab", "/mnt/a")).Should(BeFalse())
+		Expect(isUnder("/mnt", "/mnt/a")).Should(BeFalse())
+	})
+})
+
+var _ = Describe("runProbe", func() {
+	var dir string
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "probe")
+		Expect(err).ShouldNot(HaveOccurred())
+		// findMount resolves symlinks(e.g. /tmp on macOS)
+		dir, err = filepath.EvalSymlinks(dir)
+		Expect(err).ShouldNot(HaveOccurred())
+	})
+	AfterEach(func() {
+		mountInfoPath = "/proc/self/mountinfo"
+		Expect(os.RemoveAll(dir)).Should(Succeed())
+	})
+
+	When("mountPoint and fsType probes", func() {
+		BeforeEach(func() {
+			// dir is a tmpfs mount point and dir/nested is a xfs mount point with a space
+			Expect(os.MkdirAll(filepath.Join(dir, "sub"), 0755)).Should(Succeed())
+			Expect(os.MkdirAll(filepath.Join(dir, "nested dir"), 0755)).Should(Succeed())
+			escaped := strings.ReplaceAll(dir, " ", `\040`)
+			mountInfo := filepath.Join(dir, "mountinfo")
+			Expect(os.WriteFile(mountInfo, []byte(strings.Join([]string{
+				"22 1 8:1 / / rw,relatime shared:1 - ext4 /dev/sda1 rw",
+				fmt.Sprintf("30 22 0:40 / %s rw - tmpfs tmpfs rw", escaped),
+				fmt.Sprintf(`31 30 8:17 / %s/nested\040dir rw shared:5 - xfs /dev/sdb1 rw`, escaped),
+			}, "\n")+"\n"), 0644)).Should(Succeed())
+			mountInfoPath = mountInfo
+		})
+
+		It("should check the path is a mount point", func() {
+			probe := config.Probe{Type: config.ProbeMountPoint}
+			Expect(runProbe(probe, dir)).Should(Succeed())
+			Expect(runProbe(probe, filepath.Join(dir, "nested dir"))).Should(Succeed())
+			Expect(runProbe(probe, filepath.Join(dir, "sub"))).Should(MatchError(ContainSubstring("is not a mount point(mounted under " + dir + ")")))
+			Expect(runProbe(probe, filepath.Join(dir, "not-exist"))).ShouldNot(Succeed())
+		})
+
+		It("should check the filesystem type of the path", func() {
+			Expect(runProbe(config.Probe{Type: config.ProbeFSType, FSTypes: []string{"tmpfs"}}, filepath.Join(dir, "sub"))).Should(Succeed())
+			Expect(runProbe(config.Probe{Type: config.ProbeFSType, FSTypes: []string{"ext4", "xfs"}}, filepath.Join(dir, "nested dir"))).Should(Succeed())
+			Expect(runProbe(config.Probe{Type: config.ProbeFSType, FSTypes: []string{"ext4"}}, dir)).
+				Should(MatchError(ContainSubstring("filesystem type of " + dir + " is tmpfs")))
+		})
+	})
+
+	It("should check the path is writable", func() {
+		probe := config.Probe{Type: config.ProbeWritable}
+		Expect(runProbe(probe, dir)).Should(Succeed())
+		entries, err := os.ReadDir(dir)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(entries).Should(BeEmpty(), "probe file should be removed")
+
+		Expect(runProbe(probe, filepath.Join(dir, "not-exist"))).Should(MatchError(ContainSubstring("is not writable")))
+	})
+
+	It("should check free space of the filesystem", func() {
+		Expect(runProbe(config.Probe{Type: config.ProbeFreeSpace}, dir)).Should(Succeed())
+		Expect(runProbe(config.Probe{Type: config.ProbeFreeSpace, MinFreeBytes: math.MaxUint64}, dir)).
+			Should(MatchError(ContainSubstring("available bytes of " + dir)))
+		Expect(runProbe(config.Probe{Type: config.ProbeFreeSpace, MinFreeInodes: math.MaxUint64}, dir)).
+			Should(MatchError(ContainSubstring("free inodes of " + dir)))
+	})
+
+	It("should check the file exists", func() {
+		Expect(os.WriteFile(filepath.Join(dir, "ready"), nil, 0644)).Should(Succeed())
+		Expect(runProbe(config.Probe{Type: config.ProbeFileExists, File: "ready"}, dir)).Should(Succeed())
+		Expect(runProbe(config.Probe{Type: config.ProbeFileExists, File: "not-exist"}, dir)).ShouldNot(Succeed())
+	})
+
+	It("should run the command with HOST_PATH", func() {
+		probe := config.Probe{Type: config.ProbeExec, Timeout: 5 * time.Second}
+		probe.Command = []string{"sh", "-c", `test "$HOST_PATH" = "` + dir + `"`}
+		Expect(runProbe(probe, dir)).Should(Succeed())
+
+		probe.Command = []string{"sh", "-c", "echo broken; exit 1"}
+		Expect(runProbe(probe, dir)).Should(MatchError(ContainSubstring("failed: broken")))
+	})
+
+	It("should time out the command", func() {
+		probe := config.Probe{Type: config.ProbeExec, Command: []string{"sleep", "10"}, Timeout: 100 * time.Millisecond}
+		start := time.Now()
+		Expect(runProbe(probe, dir)).Should(MatchError("command [sleep 10] timed out after 100ms"))
+		Expect(time.Since(start)).Should(BeNumerically("<", 5*time.Second))
+	})
+
+	It("should fail on unknown probe type", func() {
+		Expect(runProbe(config.Probe{Type: "unknown"}, dir)).Should(MatchError("unknown probe type unknown"))
+	})
+})
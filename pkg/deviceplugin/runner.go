@@ -1,24 +1,74 @@
 package deviceplugin
 
 import (
+	"math"
 	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
 	"syscall"
+	"time"
 
 	"github.com/everpeace/k8s-hostpath-device-plugin/pkg/config"
 	"github.com/everpeace/k8s-hostpath-device-plugin/pkg/watcher"
 	"github.com/fsnotify/fsnotify"
+	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
+	"k8s.io/apimachinery/pkg/util/wait"
 	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
 )
 
+// RunnerConfig holds configs of Runner
+type RunnerConfig struct {
+	// ConfigFile is the path of the config file which is reloaded on its change or SIGHUP.  Not reloaded if empty.
+	ConfigFile string
+	// StrictConfig rejects unknown fields and multiple documents in the config file on reload
+	StrictConfig bool
+	// HealthListen is the listen address of health endpoints.  Disabled if empty.
+	HealthListen string
+	// RegistrationCheckInterval is the interval of verifying device plugins are still served and registered to kubelet.
+	// The periodic check is disabled if 0.
+	RegistrationCheckInterval time.Duration
+}
+
 type Runner struct {
-	cfg       config.HostPathDevicePluginConfig
+	cfg       config.Config
+	runnerCfg RunnerConfig
 	fsWatcher *fsnotify.Watcher
-	sigCh     chan os.Signal
+	// configWatcher watches the directory of the config file.  nil when the config file is not reloaded.
+	configWatcher *fsnotify.Watcher
+	sigCh         chan os.Signal
+
+	// mu guards registered
+	mu sync.Mutex
+	// registered holds whether each resource is registered to kubelet, keyed by ResourceName
+	registered map[string]bool
+}
+
+// retry holds the state of retrying to start a device plugin
+type retry struct {
+	backoff wait.Backoff
+	attempt int
+	// at is the time of the next attempt.  Zero means immediately.
+	at time.Time
+}
+
+// newRetry returns a retry with exponential backoff from 1s to 2m with jitter
+func newRetry() *retry {
+	return &retry{
+		backoff: wait.Backoff{
+			Duration: time.Second,
+			Factor:   2,
+			Jitter:   0.2,
+			Steps:    math.MaxInt32,
+			Cap:      2 * time.Minute,
+		},
+	}
 }
 
 func MustNewRunner(
-	cfg config.HostPathDevicePluginConfig,
+	cfg config.Config,
+	runnerCfg RunnerConfig,
 ) *Runner {
 	log.Info().Str("Path", pluginapi.DevicePluginPath).Msg("Starting filesystem watcher.")
 	fsWatcher, err := watcher.NewFSWatcher(pluginapi.DevicePluginPath)
@@ -26,37 +76,106 @@ func MustNewRunner(
 		log.Fatal().Str("Path", pluginapi.DevicePluginPath).Err(err).Msg("Failed to create filesystem watcher")
 	}
 
+	var configWatcher *fsnotify.Watcher
+	if runnerCfg.ConfigFile != "" {
+		configDir := filepath.Dir(runnerCfg.ConfigFile)
+		log.Info().Str("Path", configDir).Msg("Starting config file watcher.")
+		configWatcher, err = watcher.NewFSWatcher(configDir)
+		if err != nil {
+			log.Fatal().Str("Path", configDir).Err(err).Msg("Failed to create config file watcher")
+		}
+	}
+
 	log.Info().Msg("Starting signal watcher.")
 	sigCh := watcher.NewSignalWatcher(syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
 	return &Runner{
-		cfg:       cfg,
-		fsWatcher: fsWatcher,
-		sigCh:     sigCh,
+		cfg:           cfg,
+		runnerCfg:     runnerCfg,
+		fsWatcher:     fsWatcher,
+		configWatcher: configWatcher,
+		sigCh:         sigCh,
+		registered:    map[string]bool{},
 	}
 }
 
 func (r *Runner) Run() {
-	var devicePlugin *HostPathDevicePlugin
-	restart := true
+	if r.runnerCfg.HealthListen != "" {
+		go r.serveHealth()
+	}
+	// the periodic check is disabled when the interval is 0
+	var tickerCh <-chan time.Time
+	if r.runnerCfg.RegistrationCheckInterval > 0 {
+		ticker := time.NewTicker(r.runnerCfg.RegistrationCheckInterval)
+		defer ticker.Stop()
+		tickerCh = ticker.C
+	}
+
+	// devicePlugins and retries are keyed by ResourceName
+	devicePlugins := map[string]*HostPathDevicePlugin{}
+	retries := map[string]*retry{}
+	restart := func(resourceName string) {
+		if _, ok := retries[resourceName]; !ok {
+			retries[resourceName] = newRetry()
+		}
+		retries[resourceName].at = time.Time{}
+	}
+	restartAll := func() {
+		for _, cfg := range r.cfg.Resources {
+			restart(cfg.ResourceName)
+		}
+	}
+
+	reload := func() {
+		changed, removed := r.reloadConfig(devicePlugins)
+		for _, resourceName := range removed {
+			delete(retries, resourceName)
+		}
+		for _, resourceName := range changed {
+			restart(resourceName)
+		}
+	}
+	var configEvents <-chan fsnotify.Event
+	var configErrors <-chan error
+	if r.configWatcher != nil {
+		configEvents, configErrors = r.configWatcher.Events, r.configWatcher.Errors
+	}
+
+	restartAll()
 	for {
-		if restart {
-			if devicePlugin != nil {
-				if err := devicePlugin.Stop(); err != nil {
-					log.Fatal().Err(err).Msg("Failed to stop HostPath device plugin")
+		var next time.Time
+		for _, cfg := range r.cfg.Resources {
+			rt, ok := retries[cfg.ResourceName]
+			if !ok {
+				continue
+			}
+			if rt.at.After(time.Now()) {
+				if next.IsZero() || rt.at.Before(next) {
+					next = rt.at
 				}
+				continue
 			}
 
-			var err error
-			devicePlugin, err = NewHostPathDevicePlugin(r.cfg)
-			if err != nil {
-				log.Fatal().Err(err).Msg("Failed to initialize HostPath device plugin")
+			rt.attempt++
+			if r.restartDevicePlugin(cfg, devicePlugins, rt.attempt) {
+				delete(retries, cfg.ResourceName)
+				continue
 			}
-
-			if err := devicePlugin.Serve(); err != nil {
-				log.Error().Err(err).Msg("Failed to start HostPath device plugin")
-			} else {
-				restart = false
+			delay := rt.backoff.Step()
+			rt.at = time.Now().Add(delay)
+			if next.IsZero() || rt.at.Before(next) {
+				next = rt.at
 			}
+			log.Info().
+				Str("ResourceName", cfg.ResourceName).
+				Int("Attempt", rt.attempt).
+				Dur("RetryAfter", delay).Msg("Retrying to start HostPath device plugin")
+		}
+
+		var retryCh <-chan time.Time
+		var timer *time.Timer
+		if !next.IsZero() {
+			timer = time.NewTimer(time.Until(next))
+			retryCh = timer.C
 		}
 
 		select {
@@ -66,7 +185,39 @@ func (r *Runner) Run() {
 					log.Info().
 						Str("KubeletSocket", pluginapi.KubeletSocket).
 						Msg("inotify: detected KubeletSocket created.  Restarting K8s HostPath Device Plugin")
-					restart = true
+					restartAll()
+				}
+				// kubelet removes sockets of device plugins on its restart
+				if event.Op&(fsnotify.Remove|fsnotify.Rename) != 0 {
+					for _, cfg := range r.cfg.Resources {
+						if event.Name != cfg.Socket() || !r.isRegistered(cfg.ResourceName) {
+							continue
+						}
+						// the socket is removed and re-created when the device plugin restarts by itself
+						if _, err := os.Stat(cfg.Socket()); err == nil {
+							continue
+						}
+						log.Info().
+							Str("ResourceName", cfg.ResourceName).
+							Str("Socket", cfg.Socket()).
+							Msg("inotify: detected the socket removed.  Restarting HostPath device plugin")
+						restart(cfg.ResourceName)
+					}
+				}
+			}
+
+		case <-tickerCh:
+			for _, cfg := range r.cfg.Resources {
+				devicePlugin, ok := devicePlugins[cfg.ResourceName]
+				if !ok || !r.isRegistered(cfg.ResourceName) {
+					continue
+				}
+				if err := r.verifyDevicePlugin(cfg, devicePlugin); err != nil {
+					log.Info().
+						Str("ResourceName", cfg.ResourceName).
+						Str("Reason", err.Error()).
+						Msg("Detected the device plugin drifted.  Restarting HostPath device plugin")
+					restart(cfg.ResourceName)
 				}
 			}
 
@@ -75,20 +226,172 @@ func (r *Runner) Run() {
 				log.Error().Err(err).Msg("inotify: got error")
 			}
 
+		case event, ok := <-configEvents:
+			if ok && watcher.IsConfigFileEvent(event, r.runnerCfg.ConfigFile) {
+				log.Info().
+					Str("ConfigFile", r.runnerCfg.ConfigFile).
+					Str("Event", event.String()).
+					Msg("inotify: detected config file changed.  Reloading config")
+				reload()
+			}
+
+		case err, ok := <-configErrors:
+			if ok {
+				log.Error().Err(err).Msg("inotify: got error")
+			}
+
+		case <-retryCh:
+
 		case s := <-r.sigCh:
 			switch s {
 			case syscall.SIGHUP:
-				log.Info().Str("Signal", s.String()).Msg("Received Signal.  Restarting K8s HostPath Device Plugin")
-				restart = true
+				if r.runnerCfg.ConfigFile == "" {
+					log.Info().Str("Signal", s.String()).Msg("Received Signal.  Restarting K8s HostPath Device Plugin")
+					restartAll()
+					break
+				}
+				log.Info().Str("Signal", s.String()).Msg("Received Signal.  Reloading config")
+				reload()
 			default:
 				log.Info().Str("Signal", s.String()).Msg("Received signal, shutting down")
-				if err := devicePlugin.Stop(); err != nil {
-					log.Fatal().Err(err).Msg("Failed to shutdown")
+				failed := false
+				for resourceName, devicePlugin := range devicePlugins {
+					if err := devicePlugin.Stop(); err != nil {
+						log.Error().Str("ResourceName", resourceName).Err(err).Msg("Failed to shutdown")
+						failed = true
+					}
 				}
 				r.fsWatcher.Close()
+				if r.configWatcher != nil {
+					r.configWatcher.Close()
+				}
+				if failed {
+					log.Fatal().Msg("Failed to shutdown")
+				}
 				log.Info().Msg("Shutdown successfully")
 				os.Exit(0)
 			}
 		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// restartDevicePlugin stops the running device plugin for cfg (if any) and serves new one.
+// Failures are logged per resource with the attempt count so that other resources are not
+// affected.  It returns true when the new device plugin successfully started and registered.
+func (r *Runner) restartDevicePlugin(cfg config.HostPathDevicePluginConfig, devicePlugins map[string]*HostPathDevicePlugin, attempt int) bool {
+	logger := log.With().Str("ResourceName", cfg.ResourceName).Int("Attempt", attempt).Logger()
+	r.setRegistered(cfg.ResourceName, false)
+
+	if devicePlugin, ok := devicePlugins[cfg.ResourceName]; ok {
+		if err := devicePlugin.Stop(); err != nil {
+			logger.Error().Err(err).Msg("Failed to stop HostPath device plugin")
+			return false
+		}
+		delete(devicePlugins, cfg.ResourceName)
+	}
+
+	devicePlugin, err := NewHostPathDevicePlugin(cfg)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to initialize HostPath device plugin")
+		return false
 	}
+
+	devicePlugins[cfg.ResourceName] = devicePlugin
+	if err := devicePlugin.Serve(); err != nil {
+		logger.Error().Err(err).Msg("Failed to start HostPath device plugin")
+		return false
+	}
+	r.setRegistered(cfg.ResourceName, true)
+	return true
+}
+
+// reloadConfig reloads the config file and applies it.  Device plugins of removed resources are stopped.
+// It returns names of added or changed resources to be restarted, and removed ones.  The current config is
+// kept when the new config is invalid.
+func (r *Runner) reloadConfig(devicePlugins map[string]*HostPathDevicePlugin) ([]string, []string) {
+	logger := log.With().Str("ConfigFile", r.runnerCfg.ConfigFile).Logger()
+
+	cfg, err := config.LoadConfig(r.runnerCfg.ConfigFile, r.runnerCfg.StrictConfig)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to reload config.  Keeping the current config")
+		return nil, nil
+	}
+
+	changed, removed := diffResources(r.cfg, cfg)
+	if len(changed) == 0 && len(removed) == 0 {
+		logger.Info().Msg("Config is not changed")
+		return nil, nil
+	}
+	for _, resourceName := range removed {
+		devicePlugin, ok := devicePlugins[resourceName]
+		if !ok {
+			continue
+		}
+		if err := devicePlugin.Stop(); err != nil {
+			logger.Error().Str("ResourceName", resourceName).Err(err).Msg("Failed to stop HostPath device plugin")
+		}
+		delete(devicePlugins, resourceName)
+	}
+
+	r.mu.Lock()
+	r.cfg = cfg
+	for _, resourceName := range removed {
+		delete(r.registered, resourceName)
+	}
+	r.mu.Unlock()
+
+	logger.Info().
+		Strs("Changed", changed).
+		Strs("Removed", removed).
+		Interface("Config", cfg).Msg("Config reloaded")
+	return changed, removed
+}
+
+// diffResources returns names of resources added or changed in newCfg, and removed from oldCfg
+func diffResources(oldCfg, newCfg config.Config) ([]string, []string) {
+	olds := map[string]config.HostPathDevicePluginConfig{}
+	for _, c := range oldCfg.Resources {
+		olds[c.ResourceName] = c
+	}
+	news := map[string]bool{}
+	changed, removed := []string{}, []string{}
+	for _, c := range newCfg.Resources {
+		news[c.ResourceName] = true
+		if old, ok := olds[c.ResourceName]; !ok || !reflect.DeepEqual(old, c) {
+			changed = append(changed, c.ResourceName)
+		}
+	}
+	for _, c := range oldCfg.Resources {
+		if !news[c.ResourceName] {
+			removed = append(removed, c.ResourceName)
+		}
+	}
+	return changed, removed
+}
+
+// verifyDevicePlugin returns an error when the socket of the device plugin is missing or kubelet
+// doesn't keep ListAndWatch stream which it opens for registered device plugins.
+func (r *Runner) verifyDevicePlugin(cfg config.HostPathDevicePluginConfig, devicePlugin *HostPathDevicePlugin) error {
+	if _, err := os.Stat(cfg.Socket()); err != nil {
+		return errors.Wrap(err, "socket is missing")
+	}
+	if !devicePlugin.kubeletConnected(r.runnerCfg.RegistrationCheckInterval) {
+		return errors.New("kubelet doesn't watch devices")
+	}
+	return nil
+}
+
+func (r *Runner) isRegistered(resourceName string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.registered[resourceName]
+}
+
+func (r *Runner) setRegistered(resourceName string, registered bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.registered[resourceName] = registered
 }
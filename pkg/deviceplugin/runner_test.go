@@ -0,0 +1,129 @@
+package deviceplugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/everpeace/k8s-hostpath-device-plugin/pkg/config"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Runner", func() {
+	var r *Runner
+	BeforeEach(func() {
+		r = &Runner{
+			cfg: config.Config{Resources: []config.HostPathDevicePluginConfig{
+				{ResourceName: "test.org/a"},
+				{ResourceName: "test.org/b"},
+			}},
+			registered: map[string]bool{},
+		}
+	})
+	get := func(path string) (int, map[string]string) {
+		w := httptest.NewRecorder()
+		r.healthHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		status := map[string]string{}
+		Expect(json.Unmarshal(w.Body.Bytes(), &status)).Should(Succeed())
+		return w.Code, status
+	}
+
+	It("should report registration status of each resource at health endpoints", func() {
+		r.setRegistered("test.org/a", true)
+		code, status := get("/readyz")
+		Expect(code).Should(Equal(http.StatusServiceUnavailable))
+		Expect(status).Should(Equal(map[string]string{
+			"test.org/a": "registered",
+			"test.org/b": "not registered",
+		}))
+		code, _ = get("/healthz")
+		Expect(code).Should(Equal(http.StatusOK))
+
+		r.setRegistered("test.org/b", true)
+		code, _ = get("/readyz")
+		Expect(code).Should(Equal(http.StatusOK))
+	})
+
+	It("should detect the missing socket", func() {
+		cfg := config.HostPathDevicePluginConfig{ResourceName: "test.org/a", SocketName: "not-exist.sock"}
+		dp := &HostPathDevicePlugin{config: cfg}
+		Expect(r.verifyDevicePlugin(cfg, dp)).Should(MatchError(ContainSubstring("socket is missing")))
+	})
+
+	It("should back off retries exponentially with jitter up to the cap", func() {
+		rt := newRetry()
+		last := 0.0
+		for i := 0; i < 20; i++ {
+			delay := rt.backoff.Step().Seconds()
+			Expect(delay).Should(BeNumerically("<=", 120*1.2))
+			if i < 5 {
+				Expect(delay).Should(BeNumerically(">=", last))
+			}
+			last = delay
+		}
+		Expect(last).Should(BeNumerically(">=", 120))
+	})
+})
+
+var _ = Describe("Reloading config", func() {
+	var dir string
+	var r *Runner
+	resource := func(name string, numDevices int) string {
+		return fmt.Sprintf(`
+- resourceName: %s
+  socketName: %s.sock
+  numDevices: %d
+  hostPath:
+    path: /mnt/%s
+  volumeMount:
+    mountPath: /mnt/%s`, name, name, numDevices, name, name)
+	}
+	writeConfig := func(resources ...string) {
+		Expect(os.WriteFile(filepath.Join(dir, "config.yaml"), []byte(`apiVersion: hostpath-device.k8s.io/v1alpha1
+kind: HostPathDevicePluginConfig
+resources:`+strings.Join(resources, "")), 0644)).Should(Succeed())
+	}
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "config")
+		Expect(err).ShouldNot(HaveOccurred())
+		writeConfig(resource("a", 1), resource("b", 1), resource("c", 1))
+		cfg, err := config.LoadConfig(filepath.Join(dir, "config.yaml"), true)
+		Expect(err).ShouldNot(HaveOccurred())
+		r = &Runner{
+			cfg:        cfg,
+			runnerCfg:  RunnerConfig{ConfigFile: filepath.Join(dir, "config.yaml")},
+			registered: map[string]bool{"a": true, "b": true, "c": true},
+		}
+	})
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).Should(Succeed())
+	})
+
+	It("should apply the diff of resources", func() {
+		writeConfig(resource("a", 1), resource("b", 2), resource("d", 1))
+		changed, removed := r.reloadConfig(map[string]*HostPathDevicePlugin{})
+		Expect(changed).Should(Equal([]string{"b", "d"}))
+		Expect(removed).Should(Equal([]string{"c"}))
+		Expect(r.cfg.Resources).Should(HaveLen(3))
+		Expect(r.registered).Should(Equal(map[string]bool{"a": true, "b": true}))
+
+		changed, removed = r.reloadConfig(map[string]*HostPathDevicePlugin{})
+		Expect(changed).Should(BeEmpty())
+		Expect(removed).Should(BeEmpty())
+	})
+
+	It("should keep the current config when the new one is invalid", func() {
+		current := r.cfg
+		writeConfig(resource("a", 0))
+		changed, removed := r.reloadConfig(map[string]*HostPathDevicePlugin{})
+		Expect(changed).Should(BeEmpty())
+		Expect(removed).Should(BeEmpty())
+		Expect(r.cfg).Should(Equal(current))
+	})
+})
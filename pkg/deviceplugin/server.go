@@ -2,12 +2,16 @@ package deviceplugin
 
 import (
 	"context"
-	"fmt"
 	"net"
 	"os"
+	"slices"
+	"sync"
 	"time"
 
+	"github.com/everpeace/k8s-hostpath-device-plugin/pkg/cdi"
 	"github.com/everpeace/k8s-hostpath-device-plugin/pkg/config"
+	"github.com/everpeace/k8s-hostpath-device-plugin/pkg/watcher"
+	"github.com/fsnotify/fsnotify"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"google.golang.org/grpc"
@@ -22,30 +26,37 @@ var (
 // NewHostPathDevicePlugin implements the Kubernetes device plugin API
 type HostPathDevicePlugin struct {
 	config config.HostPathDevicePluginConfig
-	devs   []*pluginapi.Device
 	stop   chan interface{}
-	health chan string
 	server *grpc.Server
 	logger zerolog.Logger
+
+	// mu guards devs, watchers, allocatedAt and watchersChangedAt
+	mu   sync.Mutex
+	devs []*hostPathDevice
+	// watchers holds notification channels of ListAndWatch streams
+	watchers map[chan struct{}]struct{}
+	// allocatedAt holds the last time each device was allocated
+	allocatedAt map[string]time.Time
+	// watchersChangedAt is the time when the device plugin registered or watchers last changed
+	watchersChangedAt time.Time
 }
 
 // NewHostPathDevicePlugin returns an initialized NewHostPathDevicePlugin
 func NewHostPathDevicePlugin(cfg config.HostPathDevicePluginConfig) (*HostPathDevicePlugin, error) {
 	dp := &HostPathDevicePlugin{
-		config: cfg,
-		devs:   make([]*pluginapi.Device, cfg.NumDevices),
-		stop:   make(chan interface{}),
-		health: make(chan string),
-		logger: log.With().Str("ResourceName", cfg.ResourceName).Logger(),
+		config:      cfg,
+		stop:        make(chan interface{}),
+		logger:      log.With().Str("ResourceName", cfg.ResourceName).Logger(),
+		watchers:    map[chan struct{}]struct{}{},
+		allocatedAt: map[string]time.Time{},
 	}
 
-	health := dp.getHostPathHealth()
-	for i := range dp.devs {
-		dp.devs[i] = &pluginapi.Device{
-			ID:     fmt.Sprint(i),
-			Health: health,
-		}
+	paths, err := dp.backingPaths()
+	if err != nil {
+		return nil, err
 	}
+	dp.devs = dp.newDevices(paths, dp.getHealths(paths))
+	dp.logger.Info().Strs("Paths", paths).Int("Devices", len(dp.devs)).Msg("Devices initialized")
 
 	return dp, nil
 }
@@ -70,15 +81,69 @@ func dial(unixSocketPath string, timeout time.Duration) (*grpc.ClientConn, error
 	return c, nil
 }
 
-func (m *HostPathDevicePlugin) getHostPathHealth() string {
+// getHealths returns health of each path
+func (m *HostPathDevicePlugin) getHealths(paths []string) map[string]string {
+	healths := make(map[string]string, len(paths))
+	for _, path := range paths {
+		healths[path] = m.getHealth(path)
+	}
+	return healths
+}
+
+// getHealth returns health of devices backed by path
+func (m *HostPathDevicePlugin) getHealth(path string) string {
 	health := pluginapi.Healthy
-	if _, err := os.Stat(m.config.HostPath.Path); os.IsNotExist(err) {
+	if err := checkHostPathType(m.config.HostPath.Path, m.config.HostPath.Type); err != nil {
 		health = pluginapi.Unhealthy
-		log.Warn().Str("HostPath", m.config.HostPath.Path).Msg("HostPath not found")
+		m.logger.Warn().Str("HostPath", m.config.HostPath.Path).Err(err).Msg("HostPath is unhealthy")
+	}
+	if path != m.config.HostPath.Path {
+		if err := checkHostPathType(path, nil); err != nil {
+			health = pluginapi.Unhealthy
+			m.logger.Warn().Str("Path", path).Err(err).Msg("Device path is unhealthy")
+		}
+	}
+	if health == pluginapi.Healthy {
+		for _, probe := range m.config.Probes {
+			if err := runProbe(probe, path); err != nil {
+				m.logger.Warn().
+					Str("Path", path).
+					Str("Probe", string(probe.Type)).
+					Bool("Optional", probe.Optional).
+					Err(err).Msg("Probe failed")
+				if !probe.Optional {
+					health = pluginapi.Unhealthy
+				}
+			}
+		}
+	}
+	for _, node := range m.config.DeviceNodes {
+		fi, err := os.Stat(node.HostPath)
+		switch {
+		case os.IsNotExist(err):
+			health = pluginapi.Unhealthy
+			m.logger.Warn().Str("DeviceNode", node.HostPath).Msg("DeviceNode not found")
+		case err != nil:
+			health = pluginapi.Unhealthy
+			m.logger.Warn().Str("DeviceNode", node.HostPath).Err(err).Msg("Failed to stat DeviceNode")
+		case fi.Mode()&os.ModeDevice == 0:
+			health = pluginapi.Unhealthy
+			m.logger.Warn().Str("DeviceNode", node.HostPath).Str("Mode", fi.Mode().String()).Msg("DeviceNode is not a device file")
+		}
 	}
 	return health
 }
 
+// aggregateHealth returns Healthy if any of healths is Healthy
+func aggregateHealth(healths map[string]string) string {
+	for _, health := range healths {
+		if health == pluginapi.Healthy {
+			return pluginapi.Healthy
+		}
+	}
+	return pluginapi.Unhealthy
+}
+
 // Start starts the gRPC server of the device plugin
 func (m *HostPathDevicePlugin) Start() error {
 	err := m.cleanup()
@@ -105,7 +170,14 @@ func (m *HostPathDevicePlugin) Start() error {
 	}
 	conn.Close()
 
+	if err := m.syncCDISpec(aggregateHealth(m.getHealths(m.paths()))); err != nil {
+		return err
+	}
+
 	go m.healthCheck()
+	if m.config.GarbageCollection != nil {
+		go m.garbageCollect()
+	}
 
 	return nil
 }
@@ -120,6 +192,9 @@ func (m *HostPathDevicePlugin) Stop() error {
 	m.server = nil
 	close(m.stop)
 
+	if err := m.removeCDISpec(); err != nil {
+		return err
+	}
 	return m.cleanup()
 }
 
@@ -145,19 +220,24 @@ func (m *HostPathDevicePlugin) Register(kubeletEndpoint, resourceName string) er
 	return nil
 }
 
-// ListAndWatch lists devices and update that list according to the health status
+// ListAndWatch lists devices and update that list according to the health status.
+// Each stream receives the current device list immediately and then every change of it.
 func (m *HostPathDevicePlugin) ListAndWatch(e *pluginapi.Empty, s pluginapi.DevicePlugin_ListAndWatchServer) error {
+	notify := m.addWatcher()
+	defer m.removeWatcher(notify)
+
+	m.logger.Info().Str("Method", "ListAndWatch").Msg("Stream opened")
+	defer m.logger.Info().Str("Method", "ListAndWatch").Msg("Stream closed")
 	for {
 		select {
 		case <-m.stop:
 			return nil
-		case health := <-m.health:
-			// Update health of devices only in this thread.
-			for _, dev := range m.devs {
-				dev.Health = health
-			}
-			log.Info().Interface("Devices", m.devs).Msg("Exposing devices")
-			if err := s.Send(&pluginapi.ListAndWatchResponse{Devices: m.devs}); err != nil {
+		case <-s.Context().Done():
+			return nil
+		case <-notify:
+			devs := m.devices()
+			m.logger.Info().Interface("Devices", devs).Msg("Exposing devices")
+			if err := s.Send(&pluginapi.ListAndWatchResponse{Devices: devs}); err != nil {
 				m.logger.Error().Err(err).Str("Method", "ListAndWatch").Msg("Failed to send device list")
 				return err
 			}
@@ -165,24 +245,190 @@ func (m *HostPathDevicePlugin) ListAndWatch(e *pluginapi.Empty, s pluginapi.Devi
 	}
 }
 
+// addWatcher registers a notification channel of a ListAndWatch stream.  The channel is
+// notified initially so that the stream sends the current device list immediately.
+func (m *HostPathDevicePlugin) addWatcher() chan struct{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	notify := make(chan struct{}, 1)
+	notify <- struct{}{}
+	m.watchers[notify] = struct{}{}
+	return notify
+}
+
+func (m *HostPathDevicePlugin) removeWatcher(notify chan struct{}) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.watchers, notify)
+	m.watchersChangedAt = time.Now()
+}
+
+// kubeletConnected returns true when kubelet keeps ListAndWatch stream.  It also returns true
+// within grace after the device plugin registered or the last stream closed, because kubelet
+// opens the stream asynchronously.
+func (m *HostPathDevicePlugin) kubeletConnected(grace time.Duration) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.watchers) > 0 || time.Since(m.watchersChangedAt) < grace
+}
+
+// devices returns a copy of the current device list
+func (m *HostPathDevicePlugin) devices() []*pluginapi.Device {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	devs := make([]*pluginapi.Device, len(m.devs))
+	for i, dev := range m.devs {
+		devs[i] = &pluginapi.Device{ID: dev.ID, Health: dev.Health, Topology: dev.Topology}
+	}
+	return devs
+}
+
+// paths returns distinct backing paths of the current devices
+func (m *HostPathDevicePlugin) paths() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	paths := []string{}
+	for _, dev := range m.devs {
+		if !slices.Contains(paths, dev.path) {
+			paths = append(paths, dev.path)
+		}
+	}
+	return paths
+}
+
+// setHealths updates health of devices by their backing paths and notifies all the ListAndWatch
+// streams if any changed.
+func (m *HostPathDevicePlugin) setHealths(healths map[string]string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	changed := false
+	for _, dev := range m.devs {
+		if health, ok := healths[dev.path]; ok && dev.Health != health {
+			dev.Health = health
+			changed = true
+		}
+	}
+	if changed {
+		m.notifyWatchers()
+	}
+}
+
+// notifyWatchers notifies all the ListAndWatch streams.  Notifications never block because
+// pending notifications are coalesced.  m.mu must be held.
+func (m *HostPathDevicePlugin) notifyWatchers() {
+	for notify := range m.watchers {
+		select {
+		case notify <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// healthCheckTargets returns paths whose creation, deletion and rename affect the health
+func (m *HostPathDevicePlugin) healthCheckTargets(paths []string) []string {
+	targets := []string{m.config.HostPath.Path}
+	for _, path := range paths {
+		if !slices.Contains(targets, path) {
+			targets = append(targets, path)
+		}
+	}
+	if m.config.DevicePaths != "" {
+		// to discover paths newly matching DevicePaths
+		targets = append(targets, globBaseDir(m.config.DevicePaths))
+	}
+	for _, node := range m.config.DeviceNodes {
+		targets = append(targets, node.HostPath)
+	}
+	return targets
+}
+
+// healthCheck checks health on filesystem events of health check targets.  It also checks
+// health periodically as a fallback in case of missing events (e.g. remount).  Paths matching
+// DevicePaths are re-discovered at the same time.
 func (m *HostPathDevicePlugin) healthCheck() {
-	log.Info().Dur("Interval", m.config.HealthCheckInterval).Msg("Starting health check")
+	m.logger.Info().Dur("Interval", m.config.HealthCheckInterval).Msg("Starting health check")
 	ticker := time.NewTicker(m.config.HealthCheckInterval)
+	defer ticker.Stop()
+
+	paths := m.paths()
+	targets := m.healthCheckTargets(paths)
+	fsWatcher, err := watcher.NewFSWatcher()
+	if err != nil {
+		m.logger.Error().Err(err).Msg("Failed to create filesystem watcher.  Health check falls back to polling")
+	} else {
+		defer fsWatcher.Close()
+	}
+	watchTargets := func() {
+		if fsWatcher == nil {
+			return
+		}
+		for _, target := range targets {
+			if err := watcher.AddPathAndParents(fsWatcher, target); err != nil {
+				m.logger.Error().Err(err).Str("Path", target).Msg("Failed to watch health check target")
+			}
+		}
+	}
+	var events chan fsnotify.Event
+	var errs chan error
+	if fsWatcher != nil {
+		events, errs = fsWatcher.Events, fsWatcher.Errors
+	}
+
+	lastHealths := map[string]string{}
 	lastHealth := "Unknown"
+	checkHealth := func() {
+		if m.discover() {
+			paths = m.paths()
+			targets = m.healthCheckTargets(paths)
+			watchTargets()
+		}
+		healths := m.getHealths(paths)
+		for _, path := range paths {
+			last, ok := lastHealths[path]
+			if !ok {
+				last = "Unknown"
+			}
+			if last != healths[path] {
+				m.logger.Info().
+					Str("Path", path).
+					Str("LastHealth", last).
+					Str("Health", healths[path]).Msg("Health is changed")
+			}
+		}
+		if health := aggregateHealth(healths); lastHealth != health {
+			if err := m.syncCDISpec(health); err != nil {
+				m.logger.Error().Err(err).Msg("Failed to sync CDI spec")
+			}
+			lastHealth = health
+		}
+		m.setHealths(healths)
+		lastHealths = healths
+	}
+
+	watchTargets()
+	checkHealth()
 	for {
 		select {
-		case <-ticker.C:
-			health := m.getHostPathHealth()
-			if lastHealth != health {
-				log.Info().
-					Str("HostPath", m.config.HostPath.Path).
-					Str("LastHealth", lastHealth).
-					Str("Health", health).Msg("Health is changed")
-				m.health <- health
+		case event := <-events:
+			relevant := m.isDevicePathCandidate(event.Name)
+			for _, target := range targets {
+				if watcher.IsPathOrParent(event.Name, target) {
+					relevant = true
+					break
+				}
 			}
-			lastHealth = health
+			if !relevant {
+				continue
+			}
+			m.logger.Debug().Str("Event", event.String()).Msg("inotify: detected health check target changed")
+			// re-watch because the targets or their parents might be re-created
+			watchTargets()
+			checkHealth()
+		case err := <-errs:
+			m.logger.Error().Err(err).Msg("inotify: got error")
+		case <-ticker.C:
+			checkHealth()
 		case <-m.stop:
-			ticker.Stop()
 			return
 		}
 	}
@@ -190,20 +436,70 @@ func (m *HostPathDevicePlugin) healthCheck() {
 
 // Allocate which return list of devices.
 func (m *HostPathDevicePlugin) Allocate(ctx context.Context, request *pluginapi.AllocateRequest) (*pluginapi.AllocateResponse, error) {
-	log.Debug().Interface("AllocateRequest", request).Msg("Start Allocate()")
+	m.logger.Debug().Interface("AllocateRequest", request).Msg("Start Allocate()")
 
+	// record allocations before creating device directories so that garbage collection doesn't remove them
+	for _, req := range request.GetContainerRequests() {
+		m.recordAllocation(req.GetDevicesIDs())
+	}
 	containerResponses := make([]*pluginapi.ContainerAllocateResponse, len(request.GetContainerRequests()))
-	for i := range request.GetContainerRequests() {
-		// this returns empty container allocate response
+	for i, req := range request.GetContainerRequests() {
+		// this returns empty container allocate response in "webhook" injection mode
 		// because webhook declares hostPath volume and volumeMounts to the Pods
 		containerResponses[i] = &pluginapi.ContainerAllocateResponse{}
+		if m.config.InjectsByCDI() {
+			// the CDI spec declares mounts, device nodes and envs
+			containerResponses[i].CDIDevices = []*pluginapi.CDIDevice{{
+				Name: cdi.QualifiedName(m.config.CDIKind(), cdiDeviceName),
+			}}
+		} else {
+			for _, node := range m.config.DeviceNodes {
+				containerResponses[i].Devices = append(containerResponses[i].Devices, &pluginapi.DeviceSpec{
+					ContainerPath: node.ContainerPath,
+					HostPath:      node.HostPath,
+					Permissions:   node.Permissions,
+				})
+			}
+		}
+		if m.config.InjectsByAllocate() {
+			if m.config.Exclusive {
+				mounts, err := m.exclusiveMounts(req.GetDevicesIDs())
+				if err != nil {
+					m.logger.Error().Err(err).Strs("DeviceIDs", req.GetDevicesIDs()).Msg("Failed to allocate devices")
+					return nil, err
+				}
+				containerResponses[i].Mounts = mounts
+			} else if !m.config.InjectsByWebhook() {
+				// in "both" mode, the webhook already mounts the HostPath at the same path
+				containerResponses[i].Mounts = []*pluginapi.Mount{{
+					ContainerPath: m.config.VolumeMount.MountPath,
+					HostPath:      m.config.HostPath.Path,
+					ReadOnly:      m.config.VolumeMount.ReadOnly,
+				}}
+			}
+
+			envs, err := m.config.RenderEnvs(req.GetDevicesIDs())
+			if err != nil {
+				m.logger.Error().Err(err).Msg("Failed to render envs")
+				return nil, err
+			}
+			containerResponses[i].Envs = envs
+		}
+		if m.config.InjectsByAllocate() || m.config.InjectsByCDI() {
+			annotations, err := m.config.RenderAnnotations(req.GetDevicesIDs())
+			if err != nil {
+				m.logger.Error().Err(err).Msg("Failed to render annotations")
+				return nil, err
+			}
+			containerResponses[i].Annotations = annotations
+		}
 	}
 
 	response := pluginapi.AllocateResponse{
 		ContainerResponses: containerResponses,
 	}
 
-	log.Debug().
+	m.logger.Debug().
 		Interface("AllocateRequest", request).
 		Interface("AllocateResponse", response).
 		Msg("Finish Allocate()")
@@ -212,16 +508,51 @@ func (m *HostPathDevicePlugin) Allocate(ctx context.Context, request *pluginapi.
 
 func (m *HostPathDevicePlugin) GetDevicePluginOptions(context.Context, *pluginapi.Empty) (*pluginapi.DevicePluginOptions, error) {
 	return &pluginapi.DevicePluginOptions{
-		PreStartRequired: false,
+		PreStartRequired:                m.config.PreStart != nil,
+		GetPreferredAllocationAvailable: m.config.AllocationPolicy != "",
 	}, nil
 }
 
-func (m *HostPathDevicePlugin) PreStartContainer(context.Context, *pluginapi.PreStartContainerRequest) (*pluginapi.PreStartContainerResponse, error) {
+// PreStartContainer prepares directories mounted to the container as PreStart specifies.  Errors are
+// returned to kubelet so that it retries rather than starting the container with broken directories.
+func (m *HostPathDevicePlugin) PreStartContainer(ctx context.Context, request *pluginapi.PreStartContainerRequest) (*pluginapi.PreStartContainerResponse, error) {
+	if m.config.PreStart == nil {
+		return &pluginapi.PreStartContainerResponse{}, nil
+	}
+	m.logger.Debug().Interface("PreStartContainerRequest", request).Msg("Start PreStartContainer()")
+
+	dirs, err := m.preStartDirs(request.GetDevicesIDs())
+	if err != nil {
+		m.logger.Error().Err(err).Strs("DeviceIDs", request.GetDevicesIDs()).Msg("Failed to prepare directories")
+		return nil, err
+	}
+	for _, dir := range dirs {
+		if err := prepareDir(dir, *m.config.PreStart); err != nil {
+			m.logger.Error().Err(err).Strs("DeviceIDs", request.GetDevicesIDs()).Str("Dir", dir).Msg("Failed to prepare directory")
+			return nil, err
+		}
+	}
+
+	m.logger.Debug().Interface("PreStartContainerRequest", request).Strs("Dirs", dirs).Msg("Finish PreStartContainer()")
 	return &pluginapi.PreStartContainerResponse{}, nil
 }
 
-func (m *HostPathDevicePlugin) GetPreferredAllocation(context.Context, *pluginapi.PreferredAllocationRequest) (*pluginapi.PreferredAllocationResponse, error) {
-	return &pluginapi.PreferredAllocationResponse{}, nil
+// GetPreferredAllocation returns preferred devices to allocate in AllocationPolicy
+func (m *HostPathDevicePlugin) GetPreferredAllocation(ctx context.Context, request *pluginapi.PreferredAllocationRequest) (*pluginapi.PreferredAllocationResponse, error) {
+	m.logger.Debug().Interface("PreferredAllocationRequest", request).Msg("Start GetPreferredAllocation()")
+
+	response := &pluginapi.PreferredAllocationResponse{}
+	for _, req := range request.GetContainerRequests() {
+		response.ContainerResponses = append(response.ContainerResponses, &pluginapi.ContainerPreferredAllocationResponse{
+			DeviceIDs: m.preferredAllocation(req),
+		})
+	}
+
+	m.logger.Debug().
+		Interface("PreferredAllocationRequest", request).
+		Interface("PreferredAllocationResponse", response).
+		Msg("Finish GetPreferredAllocation()")
+	return response, nil
 }
 
 func (m *HostPathDevicePlugin) cleanup() error {
@@ -235,7 +566,7 @@ func (m *HostPathDevicePlugin) cleanup() error {
 func (m *HostPathDevicePlugin) Serve() error {
 	err := m.Start()
 	if err != nil {
-		log.Error().Err(err).Msg("Could not start device plugin")
+		m.logger.Error().Err(err).Msg("Could not start device plugin")
 		return err
 	}
 
@@ -248,6 +579,10 @@ func (m *HostPathDevicePlugin) Serve() error {
 		return err
 	}
 
+	m.mu.Lock()
+	m.watchersChangedAt = time.Now()
+	m.mu.Unlock()
+
 	m.logger.Info().Msg("Registered device plugin with Kubelet")
 	return nil
 }
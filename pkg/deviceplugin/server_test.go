@@ -0,0 +1,420 @@
+package deviceplugin
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/everpeace/k8s-hostpath-device-plugin/pkg/config"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc"
+	corev1 "k8s.io/api/core/v1"
+	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
+)
+
+type fakeListAndWatchServer struct {
+	grpc.ServerStream
+	ctx  context.Context
+	resp chan *pluginapi.ListAndWatchResponse
+}
+
+func newFakeListAndWatchServer(ctx context.Context) *fakeListAndWatchServer {
+	return &fakeListAndWatchServer{ctx: ctx, resp: make(chan *pluginapi.ListAndWatchResponse, 10)}
+}
+
+func (s *fakeListAndWatchServer) Send(resp *pluginapi.ListAndWatchResponse) error {
+	s.resp <- resp
+	return nil
+}
+
+func (s *fakeListAndWatchServer) Context() context.Context {
+	return s.ctx
+}
+
+var _ = Describe("ListAndWatch", func() {
+	var dp *HostPathDevicePlugin
+	var hostPath string
+	healths := func(resp *pluginapi.ListAndWatchResponse) []string {
+		hs := []string{}
+		for _, dev := range resp.Devices {
+			hs = append(hs, dev.Health)
+		}
+		return hs
+	}
+
+	BeforeEach(func() {
+		var err error
+		hostPath, err = os.MkdirTemp("", "hostpath")
+		Expect(err).ShouldNot(HaveOccurred())
+		dp, err = NewHostPathDevicePlugin(config.HostPathDevicePluginConfig{
+			ResourceName: "test.org/test-resource",
+			HostPath:     corev1.HostPathVolumeSource{Path: hostPath},
+			NumDevices:   2,
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+	})
+	AfterEach(func() {
+		Expect(os.RemoveAll(hostPath)).Should(Succeed())
+	})
+
+	It("should send the current devices immediately and every change to all the streams", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		streams := []*fakeListAndWatchServer{newFakeListAndWatchServer(ctx), newFakeListAndWatchServer(ctx)}
+		for _, s := range streams {
+			go func(s *fakeListAndWatchServer) {
+				defer GinkgoRecover()
+				Expect(dp.ListAndWatch(&pluginapi.Empty{}, s)).Should(Succeed())
+			}(s)
+		}
+
+		for _, s := range streams {
+			Eventually(s.resp).Should(Receive(WithTransform(healths, Equal([]string{pluginapi.Healthy, pluginapi.Healthy}))))
+		}
+
+		dp.setHealths(map[string]string{hostPath: pluginapi.Unhealthy})
+		for _, s := range streams {
+			Eventually(s.resp).Should(Receive(WithTransform(healths, Equal([]string{pluginapi.Unhealthy, pluginapi.Unhealthy}))))
+		}
+	})
+
+	It("should unregister the stream when it is closed", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		s := newFakeListAndWatchServer(ctx)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			_ = dp.ListAndWatch(&pluginapi.Empty{}, s)
+		}()
+		Eventually(s.resp).Should(Receive())
+
+		cancel()
+		Eventually(done).Should(BeClosed())
+		dp.mu.Lock()
+		defer dp.mu.Unlock()
+		Expect(dp.watchers).Should(BeEmpty())
+	})
+
+	It("should tell whether kubelet keeps the stream", func() {
+		Expect(dp.kubeletConnected(time.Minute)).Should(BeFalse())
+
+		ctx, cancel := context.WithCancel(context.Background())
+		s := newFakeListAndWatchServer(ctx)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			_ = dp.ListAndWatch(&pluginapi.Empty{}, s)
+		}()
+		Eventually(s.resp).Should(Receive())
+		Expect(dp.kubeletConnected(0)).Should(BeTrue())
+
+		cancel()
+		Eventually(done).Should(BeClosed())
+		Expect(dp.kubeletConnected(time.Minute)).Should(BeTrue())
+		Expect(dp.kubeletConnected(0)).Should(BeFalse())
+	})
+
+	It("should not block updating health without streams", func() {
+		dp.setHealths(map[string]string{hostPath: pluginapi.Unhealthy})
+		dp.setHealths(map[string]string{hostPath: pluginapi.Healthy})
+		Expect(dp.devices()).Should(HaveLen(2))
+	})
+})
+
+var _ = Describe("Devices backed by DevicePaths", func() {
+	var hostPath string
+	BeforeEach(func() {
+		var err error
+		hostPath, err = os.MkdirTemp("", "hostpath")
+		Expect(err).ShouldNot(HaveOccurred())
+		for _, disk := range []string{"disk1", "disk2", "other"} {
+			Expect(os.Mkdir(filepath.Join(hostPath, disk), 0755)).Should(Succeed())
+		}
+	})
+	AfterEach(func() {
+		Expect(os.RemoveAll(hostPath)).Should(Succeed())
+	})
+
+	newDevicePlugin := func(numDevices int) *HostPathDevicePlugin {
+		dp, err := NewHostPathDevicePlugin(config.HostPathDevicePluginConfig{
+			ResourceName: "test.org/test-resource",
+			HostPath:     corev1.HostPathVolumeSource{Path: hostPath},
+			DevicePaths:  filepath.Join(hostPath, "disk*"),
+			NumDevices:   numDevices,
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+		return dp
+	}
+
+	It("should serve devices per matched path and check their health independently", func() {
+		dp := newDevicePlugin(2)
+		disk1, disk2 := filepath.Join(hostPath, "disk1"), filepath.Join(hostPath, "disk2")
+		Expect(dp.paths()).Should(Equal([]string{disk1, disk2}))
+		Expect(dp.devices()).Should(Equal([]*pluginapi.Device{
+			{ID: dp.deviceID(disk1, 0, 0), Health: pluginapi.Healthy},
+			{ID: dp.deviceID(disk1, 1, 0), Health: pluginapi.Healthy},
+			{ID: dp.deviceID(disk2, 0, 0), Health: pluginapi.Healthy},
+			{ID: dp.deviceID(disk2, 1, 0), Health: pluginapi.Healthy},
+		}))
+
+		Expect(os.Remove(disk2)).Should(Succeed())
+		dp.setHealths(dp.getHealths(dp.paths()))
+		Expect(dp.devices()).Should(Equal([]*pluginapi.Device{
+			{ID: dp.deviceID(disk1, 0, 0), Health: pluginapi.Healthy},
+			{ID: dp.deviceID(disk1, 1, 0), Health: pluginapi.Healthy},
+			{ID: dp.deviceID(disk2, 0, 0), Health: pluginapi.Unhealthy},
+			{ID: dp.deviceID(disk2, 1, 0), Health: pluginapi.Unhealthy},
+		}))
+	})
+
+	It("should derive stable device IDs from paths", func() {
+		dp := newDevicePlugin(1)
+		disk1 := filepath.Join(hostPath, "disk1")
+		Expect(dp.deviceID(disk1, 0, 0)).Should(MatchRegexp(`^disk1-[0-9a-f]{8}$`))
+		Expect(dp.deviceID(disk1, 0, 0)).Should(Equal(dp.deviceID(disk1, 0, 1)))
+		Expect(dp.deviceID(disk1, 0, 0)).ShouldNot(Equal(dp.deviceID(filepath.Join(hostPath, "disk2"), 0, 0)))
+		Expect(newDevicePlugin(2).deviceID(disk1, 1, 0)).Should(MatchRegexp(`^disk1-[0-9a-f]{8}-1$`))
+	})
+
+	It("should discover added and removed paths and push them to ListAndWatch streams", func() {
+		dp := newDevicePlugin(1)
+		disk1, disk2, disk3 := filepath.Join(hostPath, "disk1"), filepath.Join(hostPath, "disk2"), filepath.Join(hostPath, "disk3")
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		s := newFakeListAndWatchServer(ctx)
+		go func() {
+			defer GinkgoRecover()
+			Expect(dp.ListAndWatch(&pluginapi.Empty{}, s)).Should(Succeed())
+		}()
+		Eventually(s.resp).Should(Receive())
+
+		Expect(dp.discover()).Should(BeFalse())
+
+		Expect(os.Mkdir(disk3, 0755)).Should(Succeed())
+		Expect(os.Remove(disk2)).Should(Succeed())
+		Expect(dp.discover()).Should(BeTrue())
+		Expect(dp.paths()).Should(Equal([]string{disk1, disk3}))
+		expected := []*pluginapi.Device{
+			{ID: dp.deviceID(disk1, 0, 0), Health: pluginapi.Healthy},
+			{ID: dp.deviceID(disk3, 0, 0), Health: pluginapi.Healthy},
+		}
+		Expect(dp.devices()).Should(Equal(expected))
+		var resp *pluginapi.ListAndWatchResponse
+		Eventually(s.resp).Should(Receive(&resp))
+		Expect(resp.Devices).Should(Equal(expected))
+	})
+
+	It("should match paths to discover", func() {
+		dp := newDevicePlugin(1)
+		Expect(dp.isDevicePathCandidate(filepath.Join(hostPath, "disk9"))).Should(BeTrue())
+		Expect(dp.isDevicePathCandidate(filepath.Join(hostPath, "other"))).Should(BeFalse())
+		Expect(globBaseDir("/mnt/disks/ssd*")).Should(Equal("/mnt/disks"))
+		Expect(globBaseDir("/mnt/*/data")).Should(Equal("/mnt"))
+	})
+})
+
+var _ = Describe("Allocate in exclusive mode", func() {
+	var hostPath string
+	BeforeEach(func() {
+		var err error
+		hostPath, err = os.MkdirTemp("", "hostpath")
+		Expect(err).ShouldNot(HaveOccurred())
+	})
+	AfterEach(func() {
+		Expect(os.RemoveAll(hostPath)).Should(Succeed())
+	})
+
+	allocate := func(dp *HostPathDevicePlugin, deviceIDs ...string) ([]*pluginapi.Mount, error) {
+		resp, err := dp.Allocate(context.Background(), &pluginapi.AllocateRequest{
+			ContainerRequests: []*pluginapi.ContainerAllocateRequest{{DevicesIDs: deviceIDs}},
+		})
+		if err != nil {
+			return nil, err
+		}
+		return resp.ContainerResponses[0].Mounts, nil
+	}
+
+	It("should mount subdirectories named by the allocated device IDs", func() {
+		dp, err := NewHostPathDevicePlugin(config.HostPathDevicePluginConfig{
+			ResourceName: "test.org/test-resource",
+			HostPath:     corev1.HostPathVolumeSource{Path: hostPath},
+			VolumeMount:  corev1.VolumeMount{MountPath: "/data"},
+			NumDevices:   3,
+			Injection:    config.InjectionAllocate,
+			Exclusive:    true,
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		Expect(allocate(dp, "1")).Should(Equal([]*pluginapi.Mount{
+			{ContainerPath: "/data", HostPath: filepath.Join(hostPath, "1")},
+		}))
+		Expect(filepath.Join(hostPath, "1")).Should(BeADirectory())
+
+		Expect(allocate(dp, "0", "2")).Should(Equal([]*pluginapi.Mount{
+			{ContainerPath: "/data/0", HostPath: filepath.Join(hostPath, "0")},
+			{ContainerPath: "/data/2", HostPath: filepath.Join(hostPath, "2")},
+		}))
+
+		_, err = allocate(dp, "3")
+		Expect(err).Should(HaveOccurred())
+	})
+
+	It("should mount the paths matched by DevicePaths", func() {
+		disk1 := filepath.Join(hostPath, "disk1")
+		Expect(os.Mkdir(disk1, 0755)).Should(Succeed())
+		dp, err := NewHostPathDevicePlugin(config.HostPathDevicePluginConfig{
+			ResourceName: "test.org/test-resource",
+			HostPath:     corev1.HostPathVolumeSource{Path: hostPath},
+			VolumeMount:  corev1.VolumeMount{MountPath: "/data", ReadOnly: true},
+			DevicePaths:  filepath.Join(hostPath, "disk*"),
+			NumDevices:   1,
+			Injection:    config.InjectionAllocate,
+			Exclusive:    true,
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		Expect(allocate(dp, dp.deviceID(disk1, 0, 0))).Should(Equal([]*pluginapi.Mount{
+			{ContainerPath: "/data", HostPath: disk1, ReadOnly: true},
+		}))
+	})
+})
+
+var _ = Describe("Health check", func() {
+	var dir string
+	var dp *HostPathDevicePlugin
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "healthcheck")
+		Expect(err).ShouldNot(HaveOccurred())
+		dp, err = NewHostPathDevicePlugin(config.HostPathDevicePluginConfig{
+			ResourceName: "test.org/test-resource",
+			HostPath:     corev1.HostPathVolumeSource{Path: filepath.Join(dir, "a", "b")},
+			NumDevices:   1,
+			// events must drive the health check before the periodic resync
+			HealthCheckInterval: time.Hour,
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+		go dp.healthCheck()
+	})
+	AfterEach(func() {
+		close(dp.stop)
+		Expect(os.RemoveAll(dir)).Should(Succeed())
+	})
+
+	It("should detect creation and deletion of the host path and its parents by filesystem events", func() {
+		health := func() string { return dp.devices()[0].Health }
+		Expect(health()).Should(Equal(pluginapi.Unhealthy))
+
+		By("creating the missing parents and the host path")
+		Expect(os.MkdirAll(filepath.Join(dir, "a", "b"), 0755)).Should(Succeed())
+		Eventually(health, 5*time.Second).Should(Equal(pluginapi.Healthy))
+
+		By("removing the parent")
+		Expect(os.RemoveAll(filepath.Join(dir, "a"))).Should(Succeed())
+		Eventually(health, 5*time.Second).Should(Equal(pluginapi.Unhealthy))
+	})
+})
+
+var _ = Describe("Allocate", func() {
+	var hostPath string
+	BeforeEach(func() {
+		var err error
+		hostPath, err = os.MkdirTemp("", "hostpath")
+		Expect(err).ShouldNot(HaveOccurred())
+	})
+	AfterEach(func() {
+		Expect(os.RemoveAll(hostPath)).Should(Succeed())
+	})
+
+	// allocate allocates deviceIDs with the config modified by mutate
+	allocate := func(injection config.InjectionMode, mutate func(*config.HostPathDevicePluginConfig), deviceIDs ...string) *pluginapi.ContainerAllocateResponse {
+		cfg := config.HostPathDevicePluginConfig{
+			ResourceName: "test.org/test-resource",
+			HostPath:     corev1.HostPathVolumeSource{Path: hostPath},
+			VolumeMount:  corev1.VolumeMount{MountPath: "/data", ReadOnly: true},
+			NumDevices:   2,
+			Injection:    injection,
+			CDISpecDir:   filepath.Join(hostPath, "cdi"),
+		}
+		if mutate != nil {
+			mutate(&cfg)
+		}
+		dp, err := NewHostPathDevicePlugin(cfg)
+		Expect(err).ShouldNot(HaveOccurred())
+		if len(deviceIDs) == 0 {
+			deviceIDs = []string{"0"}
+		}
+		resp, err := dp.Allocate(context.Background(), &pluginapi.AllocateRequest{
+			ContainerRequests: []*pluginapi.ContainerAllocateRequest{{DevicesIDs: deviceIDs}},
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(resp.ContainerResponses).Should(HaveLen(1))
+		return resp.ContainerResponses[0]
+	}
+
+	It("should return the mount only in allocate injection mode", func() {
+		Expect(allocate(config.InjectionWebhook, nil).Mounts).Should(BeEmpty())
+		Expect(allocate(config.InjectionAllocate, nil).Mounts).Should(Equal([]*pluginapi.Mount{{
+			ContainerPath: "/data",
+			HostPath:      hostPath,
+			ReadOnly:      true,
+		}}))
+		// the webhook mounts the HostPath at the same path
+		Expect(allocate(config.InjectionBoth, nil).Mounts).Should(BeEmpty())
+		// the CDI spec declares the mount
+		Expect(allocate(config.InjectionCDI, nil).Mounts).Should(BeEmpty())
+	})
+
+	It("should return device nodes except in cdi injection mode", func() {
+		withDeviceNodes := func(cfg *config.HostPathDevicePluginConfig) {
+			cfg.DeviceNodes = []config.DeviceNode{
+				{HostPath: "/dev/fuse", ContainerPath: "/dev/fuse", Permissions: "rwm"},
+				{HostPath: "/dev/nvidia0", ContainerPath: "/dev/gpu", Permissions: "rw"},
+			}
+		}
+		expected := []*pluginapi.DeviceSpec{
+			{HostPath: "/dev/fuse", ContainerPath: "/dev/fuse", Permissions: "rwm"},
+			{HostPath: "/dev/nvidia0", ContainerPath: "/dev/gpu", Permissions: "rw"},
+		}
+		for _, injection := range []config.InjectionMode{config.InjectionWebhook, config.InjectionAllocate, config.InjectionBoth} {
+			By(string(injection))
+			Expect(allocate(injection, withDeviceNodes).Devices).Should(Equal(expected))
+		}
+		// the CDI spec declares the device nodes
+		Expect(allocate(config.InjectionCDI, withDeviceNodes).Devices).Should(BeEmpty())
+		Expect(allocate(config.InjectionAllocate, nil).Devices).Should(BeEmpty())
+	})
+
+	It("should return envs and annotations rendered with the allocated device IDs", func() {
+		withTemplates := func(cfg *config.HostPathDevicePluginConfig) {
+			cfg.Envs = map[string]string{
+				"DATA_DIR":   "{{ .MountPath }}",
+				"DEVICE_IDS": `{{ join .DeviceIDs "," }}`,
+			}
+			cfg.Annotations = map[string]string{
+				"test.org/resource": "{{ .ResourceName }}={{ .HostPath }}",
+			}
+		}
+		expectedEnvs := map[string]string{"DATA_DIR": "/data", "DEVICE_IDS": "0,1"}
+		expectedAnnotations := map[string]string{"test.org/resource": "test.org/test-resource=" + hostPath}
+
+		By("allocate and both injection modes")
+		for _, injection := range []config.InjectionMode{config.InjectionAllocate, config.InjectionBoth} {
+			resp := allocate(injection, withTemplates, "0", "1")
+			Expect(resp.Envs).Should(Equal(expectedEnvs))
+			Expect(resp.Annotations).Should(Equal(expectedAnnotations))
+		}
+
+		By("webhook injection mode where the webhook injects them")
+		resp := allocate(config.InjectionWebhook, withTemplates, "0", "1")
+		Expect(resp.Envs).Should(BeEmpty())
+		Expect(resp.Annotations).Should(BeEmpty())
+
+		By("cdi injection mode where the CDI spec declares envs")
+		resp = allocate(config.InjectionCDI, withTemplates, "0", "1")
+		Expect(resp.Envs).Should(BeEmpty())
+		Expect(resp.Annotations).Should(Equal(expectedAnnotations))
+	})
+})
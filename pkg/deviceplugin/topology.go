@@ -0,0 +1,79 @@
+package deviceplugin
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
+)
+
+var (
+	// sysfsRoot is the mount point of sysfs.  This is replaced in tests.
+	sysfsRoot = "/sys"
+)
+
+// topology returns the topology of devices backed by path.  The NUMA node detected from sysfs is
+// preferred when DetectNUMANode is true.  Otherwise, NUMANodes are used.  It returns nil when no
+// NUMA node is known.
+func (m *HostPathDevicePlugin) topology(path string) *pluginapi.TopologyInfo {
+	if m.config.DetectNUMANode {
+		node, err := detectNUMANode(path)
+		if err == nil {
+			return &pluginapi.TopologyInfo{Nodes: []*pluginapi.NUMANode{{ID: node}}}
+		}
+		m.logger.Debug().Err(err).Str("Path", path).Msg("Failed to detect NUMA node")
+	}
+	if len(m.config.NUMANodes) == 0 {
+		return nil
+	}
+	nodes := make([]*pluginapi.NUMANode, 0, len(m.config.NUMANodes))
+	for _, id := range m.config.NUMANodes {
+		nodes = append(nodes, &pluginapi.NUMANode{ID: id})
+	}
+	return &pluginapi.TopologyInfo{Nodes: nodes}
+}
+
+// detectNUMANode detects the NUMA node of the block device which path is on
+func detectNUMANode(path string) (int64, error) {
+	var st unix.Stat_t
+	if err := unix.Stat(path, &st); err != nil {
+		return 0, errors.Wrapf(err, "failed to stat %s", path)
+	}
+	return numaNodeOfBlockDevice(unix.Major(st.Dev), unix.Minor(st.Dev))
+}
+
+// numaNodeOfBlockDevice looks up numa_node of the block device(major:minor) and its ancestors
+// (e.g. the partition -> the disk -> the NVMe controller -> the PCI device) in sysfs.
+func numaNodeOfBlockDevice(major, minor uint32) (int64, error) {
+	dev := filepath.Join(sysfsRoot, "dev", "block", fmt.Sprintf("%d:%d", major, minor))
+	dir, err := filepath.EvalSymlinks(dev)
+	if err != nil {
+		return 0, errors.Wrapf(err, "block device %d:%d not found in sysfs", major, minor)
+	}
+
+	devices := filepath.Join(sysfsRoot, "devices")
+	for ; strings.HasPrefix(dir, devices+"/"); dir = filepath.Dir(dir) {
+		b, err := os.ReadFile(filepath.Join(dir, "numa_node"))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		node, err := strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+		if err != nil {
+			return 0, errors.Wrapf(err, "failed to parse numa_node of %s", dir)
+		}
+		// the kernel reports -1 when the device is not attached to a specific NUMA node
+		if node < 0 {
+			return 0, errors.Errorf("block device %d:%d is not attached to a NUMA node", major, minor)
+		}
+		return node, nil
+	}
+	return 0, errors.Errorf("numa_node of block device %d:%d not found in sysfs", major, minor)
+}
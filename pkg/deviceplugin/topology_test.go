@@ -0,0 +1,72 @@
+package deviceplugin
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/everpeace/k8s-hostpath-device-plugin/pkg/config"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
+)
+
+var _ = Describe("NUMA topology", func() {
+	var root string
+	BeforeEach(func() {
+		var err error
+		root, err = os.MkdirTemp("", "sysfs")
+		Expect(err).ShouldNot(HaveOccurred())
+		sysfsRoot = root
+
+		// a partition of a NVMe disk on a PCI device attached to NUMA node 1, and
+		// a virtual block device which is not attached to any NUMA node
+		pci := filepath.Join(root, "devices", "pci0000:00", "0000:00:1d.0")
+		partition := filepath.Join(pci, "nvme", "nvme0", "nvme0n1", "nvme0n1p1")
+		virtual := filepath.Join(root, "devices", "virtual", "block", "loop0")
+		Expect(os.MkdirAll(partition, 0755)).Should(Succeed())
+		Expect(os.MkdirAll(virtual, 0755)).Should(Succeed())
+		Expect(os.WriteFile(filepath.Join(pci, "numa_node"), []byte("1\n"), 0644)).Should(Succeed())
+		Expect(os.WriteFile(filepath.Join(root, "devices", "virtual", "numa_node"), []byte("-1\n"), 0644)).Should(Succeed())
+		Expect(os.MkdirAll(filepath.Join(root, "dev", "block"), 0755)).Should(Succeed())
+		Expect(os.Symlink(partition, filepath.Join(root, "dev", "block", "259:1"))).Should(Succeed())
+		Expect(os.Symlink(virtual, filepath.Join(root, "dev", "block", "7:0"))).Should(Succeed())
+	})
+	AfterEach(func() {
+		sysfsRoot = "/sys"
+		Expect(os.RemoveAll(root)).Should(Succeed())
+	})
+
+	It("should detect NUMA node of block devices from sysfs", func() {
+		Expect(numaNodeOfBlockDevice(259, 1)).Should(Equal(int64(1)))
+
+		_, err := numaNodeOfBlockDevice(7, 0)
+		Expect(err).Should(HaveOccurred())
+
+		_, err = numaNodeOfBlockDevice(8, 0)
+		Expect(err).Should(HaveOccurred())
+	})
+
+	It("should fall back to numaNodes when NUMA node is not detected", func() {
+		hostPath, err := os.MkdirTemp("", "hostpath")
+		Expect(err).ShouldNot(HaveOccurred())
+		defer os.RemoveAll(hostPath)
+
+		dp, err := NewHostPathDevicePlugin(config.HostPathDevicePluginConfig{
+			ResourceName:   "test.org/test-resource",
+			HostPath:       corev1.HostPathVolumeSource{Path: hostPath},
+			NumDevices:     2,
+			NUMANodes:      []int64{0, 1},
+			DetectNUMANode: true,
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+		for _, dev := range dp.devices() {
+			Expect(dev.Topology).Should(Equal(&pluginapi.TopologyInfo{
+				Nodes: []*pluginapi.NUMANode{{ID: 0}, {ID: 1}},
+			}))
+		}
+
+		dp.config.NUMANodes = nil
+		Expect(dp.topology(hostPath)).Should(BeNil())
+	})
+})
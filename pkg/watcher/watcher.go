@@ -1,8 +1,12 @@
 package watcher
 
 import (
+	"errors"
+	"io/fs"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strings"
 
 	"github.com/fsnotify/fsnotify"
 )
@@ -24,6 +28,32 @@ func NewFSWatcher(files ...string) (*fsnotify.Watcher, error) {
 	return watcher, nil
 }
 
+// AddPathAndParents adds path and all its parent directories to the watcher so that
+// creation, deletion and rename of path or any of its parents can be detected.  Paths
+// which don't exist are skipped.  It is safe to call this again to add paths created later.
+func AddPathAndParents(watcher *fsnotify.Watcher, path string) error {
+	for p := filepath.Clean(path); ; p = filepath.Dir(p) {
+		if err := watcher.Add(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		if p == filepath.Dir(p) {
+			return nil
+		}
+	}
+}
+
+// IsPathOrParent returns true when name is path or one of its parent directories
+func IsPathOrParent(name, path string) bool {
+	name, path = filepath.Clean(name), filepath.Clean(path)
+	return name == path || strings.HasPrefix(path, strings.TrimSuffix(name, "/")+"/")
+}
+
+// IsConfigFileEvent returns true when event in the directory of configFile may change its content.
+// ConfigMap volumes update files by swapping "..data" symlink in the directory.
+func IsConfigFileEvent(event fsnotify.Event, configFile string) bool {
+	return filepath.Clean(event.Name) == filepath.Clean(configFile) || filepath.Base(event.Name) == "..data"
+}
+
 func NewSignalWatcher(sigs ...os.Signal) chan os.Signal {
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, sigs...)
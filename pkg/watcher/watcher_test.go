@@ -0,0 +1,101 @@
+package watcher
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Watcher", func() {
+	var dir string
+	var fsWatcher *fsnotify.Watcher
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "watcher")
+		Expect(err).ShouldNot(HaveOccurred())
+		fsWatcher, err = NewFSWatcher()
+		Expect(err).ShouldNot(HaveOccurred())
+	})
+	AfterEach(func() {
+		Expect(fsWatcher.Close()).Should(Succeed())
+		Expect(os.RemoveAll(dir)).Should(Succeed())
+	})
+	// receive returns the first event which matches
+	receive := func(match func(fsnotify.Event) bool) fsnotify.Event {
+		timeout := time.After(5 * time.Second)
+		for {
+			select {
+			case event := <-fsWatcher.Events:
+				if match(event) {
+					return event
+				}
+			case err := <-fsWatcher.Errors:
+				Fail(err.Error())
+			case <-timeout:
+				Fail("timed out waiting for the event")
+			}
+		}
+	}
+
+	It("should match the path and its parents", func() {
+		Expect(IsPathOrParent("/mnt/a/b", "/mnt/a/b")).Should(BeTrue())
+		Expect(IsPathOrParent("/mnt/a/b/", "/mnt/a/b")).Should(BeTrue())
+		Expect(IsPathOrParent("/mnt/a", "/mnt/a/b")).Should(BeTrue())
+		Expect(IsPathOrParent("/", "/mnt/a/b")).Should(BeTrue())
+		Expect(IsPathOrParent("/mnt/a/b/c", "/mnt/a/b")).Should(BeFalse())
+		Expect(IsPathOrParent("/mnt/ab", "/mnt/a/b")).Should(BeFalse())
+		Expect(IsPathOrParent("/mnt/a/bc", "/mnt/a/b")).Should(BeFalse())
+	})
+
+	It("should detect changes of the path and its parents including missing ones", func() {
+		path := filepath.Join(dir, "a", "b", "c")
+		Expect(AddPathAndParents(fsWatcher, path)).Should(Succeed())
+		Expect(fsWatcher.WatchList()).Should(ContainElement(dir))
+
+		By("creating a missing parent")
+		Expect(os.Mkdir(filepath.Join(dir, "a"), 0755)).Should(Succeed())
+		event := receive(func(e fsnotify.Event) bool { return IsPathOrParent(e.Name, path) })
+		Expect(event.Name).Should(Equal(filepath.Join(dir, "a")))
+		Expect(event.Has(fsnotify.Create)).Should(BeTrue())
+
+		By("adding the created parent again")
+		Expect(AddPathAndParents(fsWatcher, path)).Should(Succeed())
+		Expect(fsWatcher.WatchList()).Should(ContainElement(filepath.Join(dir, "a")))
+		Expect(os.Mkdir(filepath.Join(dir, "a", "b"), 0755)).Should(Succeed())
+		event = receive(func(e fsnotify.Event) bool { return e.Name == filepath.Join(dir, "a", "b") })
+		Expect(event.Has(fsnotify.Create)).Should(BeTrue())
+
+		By("ignoring unrelated paths")
+		Expect(IsPathOrParent(filepath.Join(dir, "other"), path)).Should(BeFalse())
+	})
+
+	It("should detect ConfigMap symlink swaps of the config file", func() {
+		// the layout of ConfigMap volumes: config.yaml -> ..data/config.yaml, ..data -> ..<timestamp>
+		writeVersion := func(version, content string) {
+			Expect(os.Mkdir(filepath.Join(dir, version), 0755)).Should(Succeed())
+			Expect(os.WriteFile(filepath.Join(dir, version, "config.yaml"), []byte(content), 0644)).Should(Succeed())
+		}
+		writeVersion("..2024_01_01", "v1")
+		Expect(os.Symlink("..2024_01_01", filepath.Join(dir, "..data"))).Should(Succeed())
+		configFile := filepath.Join(dir, "config.yaml")
+		Expect(os.Symlink(filepath.Join("..data", "config.yaml"), configFile)).Should(Succeed())
+		Expect(fsWatcher.Add(dir)).Should(Succeed())
+
+		By("swapping ..data symlink atomically as kubelet does")
+		writeVersion("..2024_01_02", "v2")
+		Expect(os.Symlink("..2024_01_02", filepath.Join(dir, "..data_tmp"))).Should(Succeed())
+		Expect(os.Rename(filepath.Join(dir, "..data_tmp"), filepath.Join(dir, "..data"))).Should(Succeed())
+		event := receive(func(e fsnotify.Event) bool { return IsConfigFileEvent(e, configFile) })
+		Expect(event.Name).Should(Equal(filepath.Join(dir, "..data")))
+		Expect(os.ReadFile(configFile)).Should(Equal([]byte("v2")))
+
+		By("matching events of the config file itself")
+		Expect(IsConfigFileEvent(fsnotify.Event{Name: configFile, Op: fsnotify.Write}, configFile)).Should(BeTrue())
+		Expect(IsConfigFileEvent(fsnotify.Event{Name: filepath.Join(dir, "..data_tmp"), Op: fsnotify.Create}, configFile)).Should(BeFalse())
+		Expect(IsConfigFileEvent(fsnotify.Event{Name: filepath.Join(dir, "other.yaml"), Op: fsnotify.Write}, configFile)).Should(BeFalse())
+	})
+})
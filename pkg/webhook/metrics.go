@@ -0,0 +1,22 @@
+package webhook
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	metricsRegistry = prometheus.NewRegistry()
+
+	configGeneration = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "k8s_hostpath_device_plugin_webhook_config_generation",
+		Help: "Generation of the active config.  It starts from 1 and increments every time a changed config is reloaded.",
+	})
+	configReloadFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "k8s_hostpath_device_plugin_webhook_config_reload_failures_total",
+		Help: "Number of rejected config reloads because the config file was invalid.",
+	})
+)
+
+func init() {
+	metricsRegistry.MustRegister(configGeneration, configReloadFailures)
+}
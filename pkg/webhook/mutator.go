@@ -2,7 +2,9 @@ package webhook
 
 import (
 	"context"
+	"sort"
 	"strings"
+	"sync/atomic"
 
 	"github.com/everpeace/k8s-hostpath-device-plugin/pkg/config"
 	"github.com/pkg/errors"
@@ -18,11 +20,26 @@ import (
 var _ kwhmutating.Mutator = &hostPathMutator{}
 
 type hostPathMutator struct {
-	cfg config.HostPathDevicePluginConfig
+	// cfg is swapped atomically on config reload
+	cfg atomic.Pointer[config.Config]
 }
 
-func NewMutator(cfg config.HostPathDevicePluginConfig) kwhmutating.Mutator {
-	return &hostPathMutator{cfg: cfg}
+func NewMutator(cfg config.Config) kwhmutating.Mutator {
+	return newHostPathMutator(cfg)
+}
+
+func newHostPathMutator(cfg config.Config) *hostPathMutator {
+	m := &hostPathMutator{}
+	m.setConfig(cfg)
+	return m
+}
+
+func (m *hostPathMutator) config() config.Config {
+	return *m.cfg.Load()
+}
+
+func (m *hostPathMutator) setConfig(cfg config.Config) {
+	m.cfg.Store(&cfg)
 }
 
 func (m *hostPathMutator) Mutate(_ context.Context, r *kwhmodel.AdmissionReview, obj metav1.Object) (*kwhmutating.MutatorResult, error) {
@@ -34,47 +51,122 @@ func (m *hostPathMutator) Mutate(_ context.Context, r *kwhmodel.AdmissionReview,
 		return &kwhmutating.MutatorResult{}, nil
 	}
 	logger := log.With().Str("Pod", pod.Namespace+"/"+pod.Name).Logger()
+	// the config is fixed during an admission even if it is reloaded
+	resources := m.config().Resources
 
-	if err := m.validateNoTargetHostPathVolume(pod.Spec); err != nil {
-		return nil, err
+	for _, cfg := range resources {
+		if err := validateNoTargetHostPathVolume(cfg, pod.Spec); err != nil {
+			return nil, err
+		}
 	}
 
-	volumeName := m.cfg.HostPathVolumeName()
-	found := false
-	mutateHostPathDeviceVolumeIfRequested := func(c *corev1.Container, l zerolog.Logger) {
-		if m.isContainerRequestHostPathDevice(*c) {
-			found = true
-			vm := m.cfg.VolumeMount.DeepCopy()
-			vm.Name = volumeName
-			c.VolumeMounts = append(c.VolumeMounts, *vm)
-			l.Info().Interface("VolumeMount", vm).Msg("VolumeMount added")
+	found := map[string]bool{}
+	mutateHostPathDeviceVolumeIfRequested := func(c *corev1.Container, l zerolog.Logger) error {
+		for _, cfg := range resources {
+			if !cfg.InjectsByWebhook() {
+				continue
+			}
+			if isContainerRequestHostPathDevice(cfg, *c) {
+				found[cfg.ResourceName] = true
+				vm := cfg.VolumeMount.DeepCopy()
+				vm.Name = cfg.HostPathVolumeName()
+				c.VolumeMounts = append(c.VolumeMounts, *vm)
+				l.Info().Str("ResourceName", cfg.ResourceName).Interface("VolumeMount", vm).Msg("VolumeMount added")
+
+				if err := addEnvs(cfg, c, l); err != nil {
+					return err
+				}
+			}
 		}
+		return nil
 	}
 	for i, c := range pod.Spec.InitContainers {
-		mutateHostPathDeviceVolumeIfRequested(&c, logger.With().Str("InitContainer", c.Name).Logger())
+		if err := mutateHostPathDeviceVolumeIfRequested(&c, logger.With().Str("InitContainer", c.Name).Logger()); err != nil {
+			return nil, err
+		}
 		pod.Spec.InitContainers[i] = c
 	}
 	for i, c := range pod.Spec.Containers {
-		mutateHostPathDeviceVolumeIfRequested(&c, logger.With().Str("Container", c.Name).Logger())
+		if err := mutateHostPathDeviceVolumeIfRequested(&c, logger.With().Str("Container", c.Name).Logger()); err != nil {
+			return nil, err
+		}
 		pod.Spec.Containers[i] = c
 	}
-	if found {
+	for _, cfg := range resources {
+		if !found[cfg.ResourceName] {
+			continue
+		}
 		volume := corev1.Volume{
-			Name: volumeName,
+			Name: cfg.HostPathVolumeName(),
 			VolumeSource: corev1.VolumeSource{
-				HostPath: m.cfg.HostPath.DeepCopy(),
+				HostPath: cfg.HostPath.DeepCopy(),
 			},
 		}
 		pod.Spec.Volumes = append(pod.Spec.Volumes, volume)
-		logger.Info().Interface("Volume", volume).Msg("Volume added")
+		logger.Info().Str("ResourceName", cfg.ResourceName).Interface("Volume", volume).Msg("Volume added")
+
+		if err := addAnnotations(cfg, pod, logger); err != nil {
+			return nil, err
+		}
 	}
 	return &kwhmutating.MutatorResult{MutatedObject: obj}, nil
 }
 
-func (m *hostPathMutator) isContainerRequestHostPathDevice(c corev1.Container) bool {
+// addEnvs adds rendered Envs to the container.  Envs already declared in the container are kept as is.
+func addEnvs(cfg config.HostPathDevicePluginConfig, c *corev1.Container, l zerolog.Logger) error {
+	envs, err := cfg.RenderEnvs(nil)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to render envs of %s resource", cfg.ResourceName)
+	}
+	declared := map[string]bool{}
+	for _, e := range c.Env {
+		declared[e.Name] = true
+	}
+	for _, name := range sortedKeys(envs) {
+		if declared[name] {
+			l.Info().Str("ResourceName", cfg.ResourceName).Str("Env", name).Msg("Env already declared. Skipped")
+			continue
+		}
+		env := corev1.EnvVar{Name: name, Value: envs[name]}
+		c.Env = append(c.Env, env)
+		l.Info().Str("ResourceName", cfg.ResourceName).Interface("Env", env).Msg("Env added")
+	}
+	return nil
+}
+
+// addAnnotations adds rendered Annotations to the pod.  Annotations already declared in the pod are kept as is.
+func addAnnotations(cfg config.HostPathDevicePluginConfig, pod *corev1.Pod, l zerolog.Logger) error {
+	annotations, err := cfg.RenderAnnotations(nil)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to render annotations of %s resource", cfg.ResourceName)
+	}
+	for _, key := range sortedKeys(annotations) {
+		if _, ok := pod.Annotations[key]; ok {
+			l.Info().Str("ResourceName", cfg.ResourceName).Str("Annotation", key).Msg("Annotation already declared. Skipped")
+			continue
+		}
+		if pod.Annotations == nil {
+			pod.Annotations = map[string]string{}
+		}
+		pod.Annotations[key] = annotations[key]
+		l.Info().Str("ResourceName", cfg.ResourceName).Str("Annotation", key).Str("Value", annotations[key]).Msg("Annotation added")
+	}
+	return nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func isContainerRequestHostPathDevice(cfg config.HostPathDevicePluginConfig, c corev1.Container) bool {
 	checkResourceList := func(rl corev1.ResourceList) bool {
 		if rl != nil {
-			q, ok := rl[corev1.ResourceName(m.cfg.ResourceName)]
+			q, ok := rl[corev1.ResourceName(cfg.ResourceName)]
 			if ok && !q.IsZero() {
 				return true
 			}
@@ -84,13 +176,13 @@ func (m *hostPathMutator) isContainerRequestHostPathDevice(c corev1.Container) b
 	return checkResourceList(c.Resources.Requests) || checkResourceList(c.Resources.Limits)
 }
 
-func (m *hostPathMutator) validateNoTargetHostPathVolume(podSpec corev1.PodSpec) error {
+func validateNoTargetHostPathVolume(cfg config.HostPathDevicePluginConfig, podSpec corev1.PodSpec) error {
 	for _, v := range podSpec.Volumes {
-		if v.HostPath != nil && strings.HasPrefix(v.HostPath.Path, m.cfg.HostPath.Path) {
+		if v.HostPath != nil && strings.HasPrefix(v.HostPath.Path, cfg.HostPath.Path) {
 			return errors.Errorf(
 				"Forbid to declare a volume with hostPath.path=%s. Request %s resource instead",
-				m.cfg.HostPath.Path,
-				m.cfg.ResourceName,
+				cfg.HostPath.Path,
+				cfg.ResourceName,
 			)
 		}
 	}
@@ -31,7 +31,9 @@ var _ = Describe("Mutator", func() {
 	var mutator kwhmutating.Mutator
 
 	BeforeEach(func() {
-		mutator = webhook.NewMutator(cfg)
+		mutator = webhook.NewMutator(config.Config{
+			Resources: []config.HostPathDevicePluginConfig{cfg},
+		})
 	})
 	Context("Non-Pod Input", func() {
 		It("shoudl return empty response", func() {
@@ -148,4 +150,236 @@ var _ = Describe("Mutator", func() {
 			})
 		})
 	})
+
+	Context("Multiple resources", func() {
+		createReview := &model.AdmissionReview{Operation: model.OperationCreate}
+		var anotherCfg = config.HostPathDevicePluginConfig{
+			ResourceName: "test.org/another-resource",
+			SocketName:   "another-resource",
+			HostPath: corev1.HostPathVolumeSource{
+				Path: "/mnt/another",
+			},
+			VolumeMount: corev1.VolumeMount{
+				MountPath: "/mnt/another",
+				ReadOnly:  true,
+			},
+			NumDevices: 100,
+		}
+		BeforeEach(func() {
+			mutator = webhook.NewMutator(config.Config{
+				Resources: []config.HostPathDevicePluginConfig{cfg, anotherCfg},
+			})
+		})
+		When("Pod has user-defined hostpath volume of any resource", func() {
+			It("should return error", func() {
+				_, err := mutator.Mutate(ctx, createReview, &corev1.Pod{
+					Spec: corev1.PodSpec{
+						Volumes: []corev1.Volume{{
+							Name: "user-defined-target-host-path",
+							VolumeSource: corev1.VolumeSource{
+								HostPath: &corev1.HostPathVolumeSource{
+									Path: anotherCfg.HostPath.Path,
+								},
+							},
+						}},
+					},
+				})
+				Expect(err).Should(MatchError(fmt.Sprintf(
+					"Forbid to declare a volume with hostPath.path=%s. Request %s resource instead",
+					anotherCfg.HostPath.Path, anotherCfg.ResourceName,
+				)))
+			})
+		})
+		When("containers request different resources", func() {
+			It("should inject volumes and volumeMounts for each requested resource", func() {
+				pod := &corev1.Pod{
+					Spec: corev1.PodSpec{
+						InitContainers: []corev1.Container{{
+							Name:  "init",
+							Image: "busybox",
+							Resources: corev1.ResourceRequirements{
+								Limits: corev1.ResourceList{
+									corev1.ResourceName(anotherCfg.ResourceName): resource.MustParse("1"),
+								},
+							},
+						}},
+						Containers: []corev1.Container{{
+							Name:  "ctr",
+							Image: "busybox",
+							Resources: corev1.ResourceRequirements{
+								Limits: corev1.ResourceList{
+									corev1.ResourceName(cfg.ResourceName):        resource.MustParse("1"),
+									corev1.ResourceName(anotherCfg.ResourceName): resource.MustParse("1"),
+								},
+							},
+						}},
+					},
+				}
+				res, err := mutator.Mutate(ctx, createReview, pod)
+				Expect(err).ShouldNot(HaveOccurred())
+
+				volumeMount := func(c config.HostPathDevicePluginConfig) corev1.VolumeMount {
+					vm := c.VolumeMount.DeepCopy()
+					vm.Name = c.HostPathVolumeName()
+					return *vm
+				}
+				expected := pod.DeepCopy()
+				expected.Spec.Volumes = []corev1.Volume{{
+					Name: cfg.HostPathVolumeName(),
+					VolumeSource: corev1.VolumeSource{
+						HostPath: cfg.HostPath.DeepCopy(),
+					},
+				}, {
+					Name: anotherCfg.HostPathVolumeName(),
+					VolumeSource: corev1.VolumeSource{
+						HostPath: anotherCfg.HostPath.DeepCopy(),
+					},
+				}}
+				expected.Spec.InitContainers[0].VolumeMounts = []corev1.VolumeMount{volumeMount(anotherCfg)}
+				expected.Spec.Containers[0].VolumeMounts = []corev1.VolumeMount{volumeMount(cfg), volumeMount(anotherCfg)}
+				Expect(res.MutatedObject.(*corev1.Pod)).Should(BeEquivalentTo(expected))
+			})
+		})
+	})
+
+	Context("Resource in allocate injection mode", func() {
+		createReview := &model.AdmissionReview{Operation: model.OperationCreate}
+		allocateCfg := cfg
+		allocateCfg.Injection = config.InjectionAllocate
+		BeforeEach(func() {
+			mutator = webhook.NewMutator(config.Config{
+				Resources: []config.HostPathDevicePluginConfig{allocateCfg},
+			})
+		})
+		When("Pod has user-defined target hostpath volume", func() {
+			It("should return error", func() {
+				_, err := mutator.Mutate(ctx, createReview, &corev1.Pod{
+					Spec: corev1.PodSpec{
+						Volumes: []corev1.Volume{{
+							Name: "user-defined-target-host-path",
+							VolumeSource: corev1.VolumeSource{
+								HostPath: &corev1.HostPathVolumeSource{
+									Path: allocateCfg.HostPath.Path,
+								},
+							},
+						}},
+					},
+				})
+				Expect(err).Should(HaveOccurred())
+			})
+		})
+		When("requesting target hostpath resource", func() {
+			It("should just return the input", func() {
+				pod := &corev1.Pod{
+					Spec: corev1.PodSpec{
+						Containers: []corev1.Container{{
+							Name:  "ctr",
+							Image: "busybox",
+							Resources: corev1.ResourceRequirements{
+								Limits: corev1.ResourceList{
+									corev1.ResourceName(allocateCfg.ResourceName): resource.MustParse("1"),
+								},
+							},
+						}},
+					},
+				}
+				expected := pod.DeepCopy()
+				res, err := mutator.Mutate(ctx, createReview, pod)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(res.MutatedObject.(*corev1.Pod)).Should(BeEquivalentTo(expected))
+			})
+		})
+	})
+
+	Context("Resource with envs and annotations", func() {
+		createReview := &model.AdmissionReview{Operation: model.OperationCreate}
+		envCfg := cfg
+		envCfg.Envs = map[string]string{
+			"HOSTPATH_DIR":  "{{ .MountPath }}",
+			"HOSTPATH_NAME": "{{ .ResourceName }}",
+		}
+		envCfg.Annotations = map[string]string{
+			"test.org/hostpath": "{{ .HostPath }}",
+		}
+		BeforeEach(func() {
+			mutator = webhook.NewMutator(config.Config{
+				Resources: []config.HostPathDevicePluginConfig{envCfg},
+			})
+		})
+		It("should inject rendered envs and annotations without overriding declared ones", func() {
+			pod := &corev1.Pod{
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{
+						Name:  "ctr",
+						Image: "busybox",
+						Env: []corev1.EnvVar{{
+							Name:  "HOSTPATH_NAME",
+							Value: "user-defined",
+						}},
+						Resources: corev1.ResourceRequirements{
+							Limits: corev1.ResourceList{
+								corev1.ResourceName(envCfg.ResourceName): resource.MustParse("1"),
+							},
+						},
+					}},
+				},
+			}
+			res, err := mutator.Mutate(ctx, createReview, pod.DeepCopy())
+			Expect(err).ShouldNot(HaveOccurred())
+
+			mutated := res.MutatedObject.(*corev1.Pod)
+			Expect(mutated.Annotations).Should(Equal(map[string]string{
+				"test.org/hostpath": envCfg.HostPath.Path,
+			}))
+			Expect(mutated.Spec.Containers[0].Env).Should(Equal([]corev1.EnvVar{{
+				Name:  "HOSTPATH_NAME",
+				Value: "user-defined",
+			}, {
+				Name:  "HOSTPATH_DIR",
+				Value: envCfg.VolumeMount.MountPath,
+			}}))
+		})
+	})
+
+	Context("Resource with envs referring to device IDs in both injection mode", func() {
+		createReview := &model.AdmissionReview{Operation: model.OperationCreate}
+		bothCfg := cfg
+		bothCfg.Injection = config.InjectionBoth
+		bothCfg.Envs = map[string]string{
+			"HOSTPATH_DIR":       "{{ .MountPath }}",
+			"HOSTPATH_DEVICE_ID": "{{ index .DeviceIDs 0 }}",
+		}
+		bothCfg.Annotations = map[string]string{
+			"test.org/device-ids": `{{ join $.DeviceIDs "," }}`,
+		}
+		BeforeEach(func() {
+			mutator = webhook.NewMutator(config.Config{
+				Resources: []config.HostPathDevicePluginConfig{bothCfg},
+			})
+		})
+		It("should skip them because Allocate renders them", func() {
+			pod := &corev1.Pod{
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{
+						Name:  "ctr",
+						Image: "busybox",
+						Resources: corev1.ResourceRequirements{
+							Limits: corev1.ResourceList{
+								corev1.ResourceName(bothCfg.ResourceName): resource.MustParse("1"),
+							},
+						},
+					}},
+				},
+			}
+			res, err := mutator.Mutate(ctx, createReview, pod)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			mutated := res.MutatedObject.(*corev1.Pod)
+			Expect(mutated.Annotations).Should(BeEmpty())
+			Expect(mutated.Spec.Containers[0].Env).Should(Equal([]corev1.EnvVar{{
+				Name:  "HOSTPATH_DIR",
+				Value: bothCfg.VolumeMount.MountPath,
+			}}))
+		})
+	})
 })
@@ -4,9 +4,13 @@ import (
 	"context"
 	"crypto/tls"
 	"net/http"
+	"path/filepath"
+	"reflect"
 	"time"
 
 	"github.com/everpeace/k8s-hostpath-device-plugin/pkg/config"
+	"github.com/everpeace/k8s-hostpath-device-plugin/pkg/watcher"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/zerolog/log"
 	kwhhttp "github.com/slok/kubewebhook/v2/pkg/http"
 	kwhmutating "github.com/slok/kubewebhook/v2/pkg/webhook/mutating"
@@ -20,14 +24,20 @@ type ServerConfig struct {
 	KeyFile                 string
 	Listen                  string
 	GracefulShutdownTimeout time.Duration
+	// ConfigFile is the path of the config file which is reloaded on its change.  Not reloaded if empty.
+	ConfigFile string
+	// StrictConfig rejects unknown fields and multiple documents in the config file on reload
+	StrictConfig bool
 }
 type Server struct {
-	cfg   config.HostPathDevicePluginConfig
+	cfg   config.Config
 	whCfg ServerConfig
+	// generation is the generation of the active config
+	generation int64
 }
 
 func NewServer(
-	cfg config.HostPathDevicePluginConfig,
+	cfg config.Config,
 	whCfg ServerConfig,
 ) *Server {
 	return &Server{cfg: cfg, whCfg: whCfg}
@@ -48,11 +58,15 @@ func (s *Server) Start(ctx context.Context) error {
 		log.Fatal().Err(err).Str("Listen", s.whCfg.Listen).Msg("Failed to initialize listener")
 	}
 
+	mutator := newHostPathMutator(s.cfg)
+	s.generation = 1
+	configGeneration.Set(float64(s.generation))
+
 	kwhLogger := newZerologKubeWebhookLogger(log.Logger)
 	wh, err := kwhmutating.NewWebhook(kwhmutating.WebhookConfig{
 		ID:      "hostPathDevice",
 		Obj:     &corev1.Pod{},
-		Mutator: NewMutator(s.cfg),
+		Mutator: mutator,
 		Logger:  kwhLogger,
 	})
 	if err != nil {
@@ -62,6 +76,10 @@ func (s *Server) Start(ctx context.Context) error {
 	eg := errgroup.Group{}
 	// Start goroutine with certwatcher running fsnotify against supplied certdir
 	eg.Go(func() error { return watcher.Start(ctx) })
+	// Start goroutine reloading the config file on its change
+	if s.whCfg.ConfigFile != "" {
+		eg.Go(func() error { return s.watchConfig(ctx, mutator) })
+	}
 	// Start webhook server
 	eg.Go(func() error {
 		mux := http.NewServeMux()
@@ -71,6 +89,7 @@ func (s *Server) Start(ctx context.Context) error {
 			w.WriteHeader(http.StatusOK)
 		})
 		mux.Handle("/mutating", kwhhttp.MustHandlerFor(kwhhttp.HandlerConfig{Webhook: wh, Logger: kwhLogger}))
+		mux.Handle("/metrics", promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{}))
 
 		go func() {
 			log.Info().Str("Listen", s.whCfg.Listen).Msg("Start listening")
@@ -91,3 +110,56 @@ func (s *Server) Start(ctx context.Context) error {
 
 	return eg.Wait()
 }
+
+// watchConfig reloads the config file on its change until ctx is done
+func (s *Server) watchConfig(ctx context.Context, mutator *hostPathMutator) error {
+	configDir := filepath.Dir(s.whCfg.ConfigFile)
+	log.Info().Str("Path", configDir).Msg("Starting config file watcher")
+	configWatcher, err := watcher.NewFSWatcher(configDir)
+	if err != nil {
+		log.Error().Err(err).Str("Path", configDir).Msg("Failed to create config file watcher")
+		return err
+	}
+	defer configWatcher.Close()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-configWatcher.Events:
+			if ok && watcher.IsConfigFileEvent(event, s.whCfg.ConfigFile) {
+				log.Info().
+					Str("ConfigFile", s.whCfg.ConfigFile).
+					Str("Event", event.String()).
+					Msg("inotify: detected config file changed.  Reloading config")
+				s.reloadConfig(mutator)
+			}
+		case err, ok := <-configWatcher.Errors:
+			if ok {
+				log.Error().Err(err).Msg("inotify: got error")
+			}
+		}
+	}
+}
+
+// reloadConfig reloads the config file and swaps the config of mutator.  Invalid configs are rejected
+// and the last good config is kept.
+func (s *Server) reloadConfig(mutator *hostPathMutator) {
+	logger := log.With().Str("ConfigFile", s.whCfg.ConfigFile).Logger()
+
+	cfg, err := config.LoadConfig(s.whCfg.ConfigFile, s.whCfg.StrictConfig)
+	if err != nil {
+		configReloadFailures.Inc()
+		logger.Error().Err(err).Int64("Generation", s.generation).Msg("Rejected invalid config.  Keeping the last good config")
+		return
+	}
+	if reflect.DeepEqual(cfg, mutator.config()) {
+		logger.Info().Int64("Generation", s.generation).Msg("Config is not changed")
+		return
+	}
+
+	mutator.setConfig(cfg)
+	s.generation++
+	configGeneration.Set(float64(s.generation))
+	logger.Info().Int64("Generation", s.generation).Interface("Config", cfg).Msg("Config reloaded")
+}
@@ -0,0 +1,73 @@
+package webhook
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/everpeace/k8s-hostpath-device-plugin/pkg/config"
+	"github.com/everpeace/k8s-hostpath-device-plugin/pkg/watcher"
+	"github.com/fsnotify/fsnotify"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+var _ = Describe("Reloading config", func() {
+	var dir, configFile string
+	var s *Server
+	var mutator *hostPathMutator
+	writeConfig := func(numDevices int) {
+		Expect(os.WriteFile(configFile, []byte(fmt.Sprintf(`
+resourceName: test.org/test-resource
+socketName: test-resource.sock
+numDevices: %d
+hostPath:
+  path: /mnt/hostpath
+volumeMount:
+  mountPath: /mnt/hostpath
+`, numDevices)), 0644)).Should(Succeed())
+	}
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "config")
+		Expect(err).ShouldNot(HaveOccurred())
+		configFile = filepath.Join(dir, "config.yaml")
+		writeConfig(1)
+		cfg, err := config.LoadConfig(configFile, true)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		s = NewServer(cfg, ServerConfig{ConfigFile: configFile})
+		s.generation = 1
+		mutator = newHostPathMutator(cfg)
+	})
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).Should(Succeed())
+	})
+
+	It("should swap the config and bump the generation on valid changes", func() {
+		writeConfig(2)
+		s.reloadConfig(mutator)
+		Expect(mutator.config().Resources[0].NumDevices).Should(Equal(2))
+		Expect(s.generation).Should(Equal(int64(2)))
+		Expect(testutil.ToFloat64(configGeneration)).Should(Equal(float64(2)))
+
+		s.reloadConfig(mutator)
+		Expect(s.generation).Should(Equal(int64(2)))
+	})
+
+	It("should keep the last good config on invalid changes", func() {
+		failures := testutil.ToFloat64(configReloadFailures)
+		writeConfig(0)
+		s.reloadConfig(mutator)
+		Expect(mutator.config().Resources[0].NumDevices).Should(Equal(1))
+		Expect(s.generation).Should(Equal(int64(1)))
+		Expect(testutil.ToFloat64(configReloadFailures)).Should(Equal(failures + 1))
+	})
+
+	It("should detect changes of the config file including ConfigMap symlink swaps", func() {
+		Expect(watcher.IsConfigFileEvent(fsnotify.Event{Name: configFile, Op: fsnotify.Write}, configFile)).Should(BeTrue())
+		Expect(watcher.IsConfigFileEvent(fsnotify.Event{Name: filepath.Join(dir, "..data"), Op: fsnotify.Create}, configFile)).Should(BeTrue())
+		Expect(watcher.IsConfigFileEvent(fsnotify.Event{Name: filepath.Join(dir, "other.yaml"), Op: fsnotify.Write}, configFile)).Should(BeFalse())
+	})
+})